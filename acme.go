@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	acmeDomains  stringList // --acme-domain，可重复指定或以逗号分隔
+	acmeCacheDir string     // --acme-cache-dir，保存账号密钥及证书，默认为--data-dir下的acme目录
+)
+
+// 通过ACME自动申请及续期证书，未设置--acme-domain时为nil
+var acmeManager *autocert.Manager
+
+// HTTP-01验证使用的监听地址
+const acmeHTTPAddr = ":80"
+
+// 解析--acme-domain，创建autocert.Manager并监听80端口完成HTTP-01验证，--check时不监听
+func initACME() error {
+	var domains []string
+	for _, item := range acmeDomains {
+		for _, domain := range strings.Split(item, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+	if acmeCacheDir == "" {
+		if dataDir == "" {
+			return errors.New("使用--acme-domain时须指定--acme-cache-dir或--data-dir，用于保存证书")
+		}
+		acmeCacheDir = filepath.Join(dataDir, "acme")
+	}
+	acmeDomains = domains
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      acmeCache{autocert.DirCache(acmeCacheDir)},
+	}
+	getCertificate = acmeGetCertificate
+	logInfo("已启用ACME证书：%s，证书保存在：%s", strings.Join(domains, ","), acmeCacheDir)
+
+	// --check只执行一次命令，不需要完成验证，也不应占用80端口
+	if checkMode {
+		return nil
+	}
+	ln, err := net.Listen("tcp", acmeHTTPAddr)
+	if err != nil {
+		return fmt.Errorf("ACME需要监听80端口以完成HTTP-01验证: %v", err)
+	}
+	go func() {
+		// 验证以外的请求重定向到HTTPS
+		if err := http.Serve(ln, acmeManager.HTTPHandler(nil)); err != nil {
+			logWarn("ACME HTTP-01验证服务已停止: %v", err)
+		}
+	}()
+	return nil
+}
+
+// 获取证书失败时记录日志，不在--acme-domain中的域名不记录
+func acmeGetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := acmeManager.GetCertificate(hello)
+	if err != nil && slices.Contains(acmeDomains, strings.TrimSuffix(hello.ServerName, ".")) {
+		logWarn("获取ACME证书失败 [%s]: %v", hello.ServerName, err)
+	}
+	return cert, err
+}
+
+// 保存证书时记录申请或续期的日志
+type acmeCache struct {
+	autocert.Cache
+}
+
+func (c acmeCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		logWarn("保存ACME证书失败 [%s]: %v", key, err)
+		return err
+	}
+	if notAfter, ok := certNotAfter(data); ok {
+		logInfo("已获取ACME证书 [%s]，有效期至%s，到期前30天自动续期", key, notAfter.In(time.Local).Format(timeFormat))
+	}
+	return nil
+}
+
+// 缓存中证书的第一个CERTIFICATE块为叶子证书，账号密钥等其他内容返回false
+func certNotAfter(data []byte) (time.Time, bool) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return cert.NotAfter, true
+	}
+}
+
+// 监听地址中的主机名，ACME时为第一个域名
+func serverHost() string {
+	if acmeManager != nil {
+		return acmeDomains[0]
+	}
+	return "localhost"
+}
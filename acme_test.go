@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// --check时不监听80端口，initACME之后80端口仍可被占用
+func TestInitACMECheckMode(t *testing.T) {
+	if ln, err := net.Listen("tcp", acmeHTTPAddr); err != nil {
+		t.Skipf("无法监听%s: %v", acmeHTTPAddr, err)
+	} else {
+		ln.Close()
+	}
+	setGlobal(t, &checkMode, true)
+	setGlobal(t, &acmeDomains, stringList{"example.com"})
+	setGlobal(t, &acmeCacheDir, t.TempDir())
+	setGlobal(t, &acmeManager, nil)
+	setGlobal(t, &getCertificate, nil)
+	if err := initACME(); err != nil {
+		t.Fatalf("initACME() = %v", err)
+	}
+	if acmeManager == nil || getCertificate == nil {
+		t.Error("--check时仍应按配置创建证书管理")
+	}
+	ln, err := net.Listen("tcp", acmeHTTPAddr)
+	if err != nil {
+		t.Fatalf("--check时不应监听%s: %v", acmeHTTPAddr, err)
+	}
+	ln.Close()
+}
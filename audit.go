@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// --audit-log，以JSON Lines记录每个接口请求
+var auditLogPath string
+
+// 一条审计记录，action、params、token在请求解析后填入
+type AuditRecord struct {
+	Time       string                 `json:"time"`
+	ClientIP   string                 `json:"client_ip"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Token      string                 `json:"token,omitempty"` // 认证通过的token标签
+	Action     string                 `json:"action,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"` // 非零值的请求参数，env的值及stdin已隐藏
+	ExecID     string                 `json:"exec_id,omitempty"`
+	StatusCode int                    `json:"status_code"`
+	DurationMs int64                  `json:"duration_ms"`
+}
+
+const (
+	auditQueueSize     = 4096
+	auditFlushInterval = time.Second
+	// 从响应开头识别exec_id时最多读取的字节数
+	auditSniffSize = 4096
+)
+
+var (
+	auditQueue chan AuditRecord
+	auditDone  = make(chan struct{})
+	auditLog   = &throttledLog{interval: 10 * time.Second}
+)
+
+const auditRecordKey contextKey = "audit_record"
+
+// 打开--audit-log，记录在后台写入，每秒刷新一次
+func initAuditLog() error {
+	if auditLogPath == "" {
+		return nil
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("打开审计日志失败: %v", err)
+	}
+	auditQueue = make(chan AuditRecord, auditQueueSize)
+	go writeAuditLog(f)
+	logInfo("审计日志写入到：%s", auditLogPath)
+	return nil
+}
+
+func writeAuditLog(f *os.File) {
+	defer close(auditDone)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec, ok := <-auditQueue:
+			if !ok {
+				if err := w.Flush(); err != nil {
+					logWarn("写入审计日志失败: %v", err)
+				}
+				return
+			}
+			if err := enc.Encode(rec); err != nil {
+				auditLog.warn("写入审计日志失败: %v", err)
+			}
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				auditLog.warn("写入审计日志失败: %v", err)
+			}
+		}
+	}
+}
+
+// 关闭服务时写入剩余的审计记录
+func closeAuditLog() {
+	if auditQueue == nil {
+		return
+	}
+	close(auditQueue)
+	<-auditDone
+}
+
+// 请求的审计记录，未启用审计日志时为nil
+func requestAuditRecord(r *http.Request) *AuditRecord {
+	rec, _ := r.Context().Value(auditRecordKey).(*AuditRecord)
+	return rec
+}
+
+// 在请求解析后记录token、action及参数
+func auditParams(r *http.Request, params RequestParams) {
+	rec := requestAuditRecord(r)
+	if rec == nil {
+		return
+	}
+	rec.Token = requestTokenLabel(r)
+	rec.Action = params.Action
+	if rec.Action == "" {
+		rec.Action = "single"
+	}
+	rec.Params = redactedParams(params)
+}
+
+// 请求参数中的非零值，env的值、stdin及新token替换为***
+func redactedParams(params RequestParams) map[string]interface{} {
+	if len(params.Env) > 0 {
+		env := make(map[string]string, len(params.Env))
+		for name := range params.Env {
+			env[name] = "***"
+		}
+		params.Env = env
+	}
+	for _, s := range []*string{&params.Stdin, &params.StdinBase64, &params.NewToken} {
+		if *s != "" {
+			*s = "***"
+		}
+	}
+	m := make(map[string]interface{})
+	v := reflect.ValueOf(params)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		field := v.Field(i)
+		if name == "action" || field.IsZero() {
+			continue
+		}
+		switch value := field.Interface().(type) {
+		case DurationParam:
+			m[name] = time.Duration(value).String()
+		case JitterParam:
+			m[name] = value.String()
+		case TimeParam:
+			m[name] = value.In(time.Local).Format(time.RFC3339)
+		default:
+			m[name] = value
+		}
+	}
+	return m
+}
+
+// 记录状态码及响应开头的内容，用于识别exec_id
+type auditWriter struct {
+	http.ResponseWriter
+	code  int
+	sniff bytes.Buffer
+}
+
+func (a *auditWriter) WriteHeader(code int) {
+	if a.code == 0 {
+		a.code = code
+	}
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *auditWriter) Write(b []byte) (int, error) {
+	if a.code == 0 {
+		a.code = http.StatusOK
+	}
+	if n := auditSniffSize - a.sniff.Len(); n > 0 {
+		a.sniff.Write(b[:min(n, len(b))])
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+func (a *auditWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+func (a *auditWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// action=attach升级为WebSocket时使用
+func (a *auditWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(a.ResponseWriter).Hijack()
+	if err == nil {
+		a.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// 响应中的exec_id：X-Remotec-Exec-Id响应头或JSON响应顶层的exec_id
+func (a *auditWriter) execID() string {
+	if id := a.Header().Get("X-Remotec-Exec-Id"); id != "" {
+		return id
+	}
+	dec := json.NewDecoder(&a.sniff)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return ""
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return ""
+		}
+		if key == "exec_id" {
+			var id string
+			json.Unmarshal(value, &id)
+			return id
+		}
+	}
+	return ""
+}
+
+// 记录每个请求，包括认证失败的请求，写入不阻塞请求处理
+func auditMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &AuditRecord{
+			Time:     start.Format(timeFormat),
+			ClientIP: clientIP(r).String(),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Action:   r.URL.Query().Get("action"),
+		}
+		aw := &auditWriter{ResponseWriter: w}
+		next(aw, r.WithContext(context.WithValue(r.Context(), auditRecordKey, rec)))
+		rec.StatusCode = aw.code
+		rec.ExecID = aw.execID()
+		rec.DurationMs = time.Since(start).Milliseconds()
+		select {
+		case auditQueue <- *rec:
+		default:
+			auditLog.warn("审计日志写入队列已满，丢弃记录")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 启用--audit-log，返回关闭审计日志后读取全部记录的函数
+func enableAuditLog(t *testing.T) func() ([]AuditRecord, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	setGlobal(t, &auditLogPath, path)
+	setGlobal(t, &auditQueue, nil)
+	setGlobal(t, &auditDone, make(chan struct{}))
+	if err := initAuditLog(); err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	t.Cleanup(func() { once.Do(closeAuditLog) })
+	return func() ([]AuditRecord, string) {
+		once.Do(closeAuditLog)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []AuditRecord
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			var rec AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatalf("审计记录不是JSON: %v: %s", err, scanner.Text())
+			}
+			records = append(records, rec)
+		}
+		return records, string(data)
+	}
+}
+
+func setTokens(t *testing.T, list ...authToken) {
+	t.Helper()
+	labels := make([]string, len(list))
+	for i, token := range list {
+		labels[i] = token.label
+	}
+	setGlobal(t, &tokens, list)
+	setGlobal(t, &tokenLabels, labels)
+	setGlobal(t, &authRequired, len(list) > 0)
+}
+
+// 按--signing-secret为请求添加签名头
+func signRequest(r *http.Request, body string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := generateID()
+	r.Header.Set("X-Remotec-Timestamp", timestamp)
+	r.Header.Set("X-Remotec-Nonce", nonce)
+	r.Header.Set("X-Remotec-Signature", sign(signaturePayload(r, timestamp, nonce, []byte(body))))
+}
+
+func TestAuditLog(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "echo ok")
+	setGlobal(t, &allowEnv, stringList{"API_KEY"})
+	setGlobal(t, &nonces, make(map[string]time.Time))
+	const body = `{"env":{"API_KEY":"env-secret"},"stdin":"stdin-secret","tags":["ops"]}`
+	tests := []struct {
+		name    string
+		secret  string // --signing-secret
+		token   string // 请求的token头
+		signed  bool
+		code    int
+		label   string
+		params  bool // 是否记录了请求参数
+		execID  bool
+		secrets []string // 不应出现在审计日志中的内容
+	}{
+		{"认证通过", "", "token-secret", false, http.StatusOK, "ci", true, true, []string{"token-secret", "env-secret", "stdin-secret"}},
+		{"token不正确", "", "wrong-token", false, http.StatusForbidden, "", false, false, []string{"wrong-token", "env-secret", "stdin-secret"}},
+		{"缺少token", "", "", false, http.StatusForbidden, "", false, false, []string{"env-secret", "stdin-secret"}},
+		{"签名通过", "signing-secret", "token-secret", true, http.StatusOK, "ci", true, true, []string{"signing-secret", "token-secret", "env-secret"}},
+		{"签名不正确", "signing-secret", "token-secret", false, http.StatusForbidden, "", false, false, []string{"signing-secret", "token-secret", "env-secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTokens(t, authToken{label: "ci", value: "token-secret"})
+			setGlobal(t, &signingSecret, tt.secret)
+			records := enableAuditLog(t)
+			handler := authMiddleware(requestHandler)
+
+			r := httptest.NewRequest(http.MethodPost, "/endpoint", strings.NewReader(body))
+			if tt.token != "" {
+				r.Header.Set("token", tt.token)
+			}
+			if tt.signed {
+				signRequest(r, body)
+			} else if tt.secret != "" {
+				r.Header.Set("X-Remotec-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+				r.Header.Set("X-Remotec-Nonce", generateID())
+				r.Header.Set("X-Remotec-Signature", strings.Repeat("0", 64))
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+
+			list, raw := records()
+			if len(list) != 1 {
+				t.Fatalf("共%d条审计记录，期望1条: %s", len(list), raw)
+			}
+			rec := list[0]
+			if rec.StatusCode != tt.code || rec.Method != http.MethodPost || rec.Path != "/endpoint" || rec.ClientIP != "192.0.2.1" {
+				t.Errorf("审计记录: %+v", rec)
+			}
+			if rec.Token != tt.label {
+				t.Errorf("token = %q，期望%q", rec.Token, tt.label)
+			}
+			if (rec.Params != nil) != tt.params {
+				t.Errorf("params = %v", rec.Params)
+			}
+			if tt.params {
+				if rec.Action != "single" || rec.Params["stdin"] != "***" {
+					t.Errorf("action = %q，params = %v", rec.Action, rec.Params)
+				}
+				if env, _ := rec.Params["env"].(map[string]interface{}); env["API_KEY"] != "***" {
+					t.Errorf("env = %v", rec.Params["env"])
+				}
+			}
+			if (rec.ExecID != "") != tt.execID {
+				t.Errorf("exec_id = %q", rec.ExecID)
+			}
+			for _, secret := range tt.secrets {
+				if strings.Contains(raw, secret) {
+					t.Errorf("审计日志中包含%s: %s", secret, raw)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactedParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params RequestParams
+		want   map[string]interface{}
+	}{
+		{"零值不记录", RequestParams{}, map[string]interface{}{}},
+		{"action不记录", RequestParams{Action: "list", ExecID: "abc"}, map[string]interface{}{"exec_id": "abc"}},
+		{"stdin", RequestParams{Stdin: "secret"}, map[string]interface{}{"stdin": "***"}},
+		{"stdin_base64", RequestParams{StdinBase64: "c2VjcmV0"}, map[string]interface{}{"stdin_base64": "***"}},
+		{"新token", RequestParams{NewToken: "secret"}, map[string]interface{}{"new_token": "***"}},
+		{"时长", RequestParams{Timeout: DurationParam(90 * time.Second)}, map[string]interface{}{"timeout": "1m30s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactedParams(tt.params)
+			if len(got) != len(tt.want) {
+				t.Fatalf("redactedParams() = %v，期望%v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v，期望%v", k, got[k], v)
+				}
+			}
+		})
+	}
+
+	params := RequestParams{Env: map[string]string{"A": "1", "B": "2"}}
+	env, _ := redactedParams(params)["env"].(map[string]string)
+	if len(env) != 2 || env["A"] != "***" || env["B"] != "***" {
+		t.Errorf("env = %v", env)
+	}
+	if params.Env["A"] != "1" {
+		t.Error("不应修改请求参数中的env")
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 认证token，label用于在日志中区分，未指定时按顺序为token1、token2…
+type authToken struct {
+	label   string
+	value   string
+	expires time.Time // 过期时间，零值表示不过期
+	rotated bool      // 已被轮换，grace到期后直接移除，不再保留在expiredTokens中
+}
+
+func (t authToken) expired(now time.Time) bool {
+	return !t.expires.IsZero() && !now.Before(t.expires)
+}
+
+var (
+	tokenFlags    stringList // --token，可重复指定或以逗号分隔，格式为value、label:value或label:value:过期时间
+	tokensLock    sync.RWMutex
+	tokens        []authToken // 接受的token，过期的token被定期移到expiredTokens
+	expiredTokens []authToken // 已过期的token，仅用于在日志中说明认证失败的原因
+	tokenLabels   []string    // 配置的全部token标签，包括已过期的
+	authRequired  bool        // 配置了token时为true，token全部过期后仍需认证
+	tokenEnv      string      // 从环境变量读取token，未指定--token时使用
+	tokenFile     string      // 从文件读取token，每行一个，未指定--token、--token-env时使用
+)
+
+var (
+	roleFlags   stringList          // --role，格式为label=action1,action2
+	roleActions map[string][]string // token标签允许的action，未指定--role的token可执行全部action
+)
+
+// --allow-actions允许的action，为空时允许全部
+var allowActions stringList
+
+type contextKey string
+
+// 请求上下文中认证通过的token标签
+const tokenLabelKey contextKey = "token_label"
+
+func authEnabled() bool {
+	return authRequired
+}
+
+// token过期时间的格式，不含时区时按服务的时区解析
+var tokenExpiryLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", timeFormat, "2006-01-02"}
+
+func parseTokenExpiry(s string) (time.Time, error) {
+	for _, layout := range tokenExpiryLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无效的token过期时间: %s", s)
+}
+
+// 按--token、--token-env、--token-file的优先级读取token
+func tokenSources() ([]string, error) {
+	switch {
+	case len(tokenFlags) > 0:
+		return tokenFlags, nil
+	case tokenEnv != "":
+		value := strings.TrimSpace(os.Getenv(tokenEnv))
+		if value == "" {
+			return nil, fmt.Errorf("--token-env指定的环境变量%s未设置或为空", tokenEnv)
+		}
+		// 避免命令继承服务端环境变量时获得token
+		os.Unsetenv(tokenEnv)
+		return []string{value}, nil
+	case tokenFile != "":
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取--token-file失败: %v", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("--token-file指定的文件为空: %s", tokenFile)
+		}
+		return lines, nil
+	}
+	return nil, nil
+}
+
+// 解析token，标签不能重复
+func initTokens() error {
+	sources, err := tokenSources()
+	if err != nil {
+		return err
+	}
+	for _, flagValue := range sources {
+		for _, item := range strings.Split(flagValue, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			label, value, ok := strings.Cut(item, ":")
+			if !ok {
+				label, value = fmt.Sprintf("token%d", len(tokenLabels)+1), item
+			}
+			value, expiry, _ := strings.Cut(value, ":")
+			if label == "" || value == "" {
+				return fmt.Errorf("无效的--token: %s，格式为value、label:value或label:value:过期时间", maskToken(item))
+			}
+			if slices.Contains(tokenLabels, label) {
+				return fmt.Errorf("--token的标签重复: %s", label)
+			}
+			tokenLabels = append(tokenLabels, label)
+			t := authToken{label: label, value: value}
+			if expiry != "" {
+				if t.expires, err = parseTokenExpiry(expiry); err != nil {
+					return fmt.Errorf("--token %s: %v", label, err)
+				}
+			}
+			if t.expired(time.Now()) {
+				logWarn("token %s已于%s过期，不会被接受", label, t.expires.In(time.Local).Format(timeFormat))
+				expiredTokens = append(expiredTokens, t)
+				continue
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	authRequired = len(tokenLabels) > 0
+	if authRequired {
+		go sweepExpiredTokens()
+	}
+	return nil
+}
+
+// 定期移除已过期的token
+func sweepExpiredTokens() {
+	for range time.Tick(time.Minute) {
+		removeExpiredTokens(time.Now())
+		pruneOneTimeTokens(time.Now())
+	}
+}
+
+func removeExpiredTokens(now time.Time) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+	tokens = slices.DeleteFunc(tokens, func(t authToken) bool {
+		if !t.expired(now) {
+			return false
+		}
+		if t.rotated {
+			logInfo("token %s轮换前的旧token已于%s失效，已移除", t.label, t.expires.In(time.Local).Format(timeFormat))
+			return true
+		}
+		logInfo("token %s已于%s过期，已移除", t.label, t.expires.In(time.Local).Format(timeFormat))
+		expiredTokens = append(expiredTokens, t)
+		return true
+	})
+}
+
+// 当前有效的token数量
+func validTokenCount() int {
+	tokensLock.RLock()
+	defer tokensLock.RUnlock()
+	n := 0
+	for _, t := range tokens {
+		if !t.expired(time.Now()) {
+			n++
+		}
+	}
+	return n
+}
+
+// 解析--role，标签须对应某个--token，action须为支持的取值
+func initRoles() error {
+	for _, item := range roleFlags {
+		label, list, ok := strings.Cut(item, "=")
+		if !ok || label == "" || list == "" {
+			return fmt.Errorf("无效的--role: %s，格式为label=action1,action2", item)
+		}
+		if !slices.Contains(tokenLabels, label) {
+			return fmt.Errorf("--role %s没有对应标签的--token", label)
+		}
+		if roleActions == nil {
+			roleActions = make(map[string][]string)
+		}
+		for _, action := range strings.Split(list, ",") {
+			action = strings.TrimSpace(action)
+			if !slices.Contains(actionNames, action) {
+				return fmt.Errorf("--role %s中不支持的action: %s", label, action)
+			}
+			roleActions[label] = append(roleActions[label], action)
+		}
+		logInfo("token %s允许的action：%s", label, strings.Join(roleActions[label], ","))
+	}
+	return nil
+}
+
+// 解析--allow-actions，可重复指定或以逗号分隔
+func initAllowActions() error {
+	var actions []string
+	for _, item := range allowActions {
+		for _, action := range strings.Split(item, ",") {
+			action = strings.TrimSpace(action)
+			if !slices.Contains(actionNames, action) {
+				return fmt.Errorf("--allow-actions中不支持的action: %s", action)
+			}
+			actions = append(actions, action)
+		}
+	}
+	allowActions = actions
+	if len(allowActions) > 0 {
+		logInfo("允许的action：%s", strings.Join(allowActions, ","))
+	}
+	return nil
+}
+
+// --read-only，只允许查看，不能执行或停止
+var readOnly bool
+
+// 只读模式下允许的action
+var readActions = []string{
+	"list", "schedules", "stream", "attach", "wait", "info", "stats", "validate", "status", "result", "history", "export",
+}
+
+// 服务端启用的action，只读模式下只保留查看类的action
+func enabledActions() []string {
+	actions := actionNames
+	if len(allowActions) > 0 {
+		actions = allowActions
+	}
+	if readOnly {
+		actions = slices.DeleteFunc(slices.Clone(actions), func(a string) bool { return !slices.Contains(readActions, a) })
+	}
+	return actions
+}
+
+// 校验服务端是否启用该action，以及请求的token是否允许执行该action
+func authorizeAction(r *http.Request, action string) error {
+	if action == "" {
+		action = "single"
+	}
+	if readOnly && !slices.Contains(readActions, action) {
+		return fmt.Errorf("只读模式下不允许action=%s", action)
+	}
+	if !slices.Contains(enabledActions(), action) {
+		return fmt.Errorf("服务端未启用action=%s", action)
+	}
+	label := requestTokenLabel(r)
+	allowed, limited := roleActions[label]
+	if t := requestOneTimeToken(r); t != nil {
+		allowed, limited = t.Actions, len(t.Actions) > 0
+	}
+	if !limited || slices.Contains(allowed, action) {
+		return nil
+	}
+	logWarn("token %s没有执行action=%s的权限", label, action)
+	return fmt.Errorf("token（%s）没有执行action=%s的权限", label, action)
+}
+
+// 启动日志中显示的token，只包含标签、首尾字符及过期时间
+func tokenSummary() string {
+	tokensLock.RLock()
+	defer tokensLock.RUnlock()
+	items := make([]string, len(tokens))
+	for i, t := range tokens {
+		mask := maskToken(t.value)
+		if !t.expires.IsZero() {
+			mask += "，" + t.expires.In(time.Local).Format(timeFormat) + "过期"
+		}
+		items[i] = fmt.Sprintf("%s(%s)", t.label, mask)
+	}
+	return strings.Join(items, "、")
+}
+
+// 返回与请求头匹配的token，以固定时间比较
+func matchToken(reqToken string) (authToken, bool) {
+	tokensLock.RLock()
+	defer tokensLock.RUnlock()
+	for _, t := range slices.Concat(tokens, expiredTokens) {
+		if subtle.ConstantTimeCompare([]byte(reqToken), []byte(t.value)) == 1 {
+			return t, true
+		}
+	}
+	return authToken{}, false
+}
+
+// 请求认证通过的token标签，未启用认证时为空
+func requestTokenLabel(r *http.Request) string {
+	label, _ := r.Context().Value(tokenLabelKey).(string)
+	return label
+}
+
+// 日志中只显示token的首尾字符
+func maskToken(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:3]) + "…" + string(runes[len(runes)-3:])
+}
+
+func tokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() {
+			next(w, r)
+			return
+		}
+		t, ok := matchToken(r.Header.Get("token"))
+		if !ok && oneTimeTokenAuth(w, r, next) {
+			return
+		}
+		if !ok {
+			logWarn("认证失败，未收到正确的token")
+			sendError(w, "未授权", http.StatusForbidden)
+			return
+		}
+		if t.expired(time.Now()) {
+			logWarn("认证失败，token %s已于%s过期", t.label, t.expires.In(time.Local).Format(timeFormat))
+			sendError(w, "token已过期", http.StatusForbidden)
+			return
+		}
+		if len(tokenLabels) > 1 {
+			logInfo("认证通过 [token:%s]", t.label)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenLabelKey, t.label)))
+	}
+}
+
+// 未通过--role限制action的token为管理token，一次性token不是管理token
+func isAdminRequest(r *http.Request) bool {
+	if requestOneTimeToken(r) != nil {
+		return false
+	}
+	_, limited := roleActions[requestTokenLabel(r)]
+	return !limited
+}
+
+// action=rotate-token的响应，token仅在随机生成时返回这一次
+type RotateTokenResult struct {
+	Label        string `json:"label"`
+	Token        string `json:"token,omitempty"`
+	OldExpiresAt string `json:"old_expires_at,omitempty"` // 旧token在grace期间继续有效，未指定grace时立即失效
+	ExpiresAt    string `json:"expires_at,omitempty"`     // 新token沿用旧token的过期时间
+	Message      string `json:"message"`
+}
+
+// 运行时轮换token：以新token替换该标签的token，旧token可在grace期间继续有效
+// 新token沿用旧token的过期时间，轮换不能延长有时限的token
+func handleRotateToken(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if !authEnabled() {
+		sendError(w, "未设置token，无法轮换", http.StatusBadRequest)
+		return
+	}
+	// 避免token出现在URL及访问日志中
+	if r.Method != http.MethodPost {
+		sendError(w, "轮换token需使用POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := requestTokenLabel(r)
+	if !isAdminRequest(r) {
+		logWarn("token %s没有轮换token的权限", caller)
+		sendError(w, fmt.Sprintf("token（%s）没有轮换token的权限，仅未限制--role的token可以轮换", caller), http.StatusForbidden)
+		return
+	}
+	label := params.TokenLabel
+	if label == "" {
+		label = caller
+	}
+	if params.Grace < 0 {
+		sendError(w, "grace不能为负数", http.StatusBadRequest)
+		return
+	}
+
+	result := RotateTokenResult{Label: label}
+	value := params.NewToken
+	if value == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			sendError(w, "生成token失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value = hex.EncodeToString(buf)
+		result.Token = value
+	} else if strings.ContainsAny(value, ":,") || strings.TrimSpace(value) != value {
+		sendError(w, "new_token不能包含冒号、逗号或首尾空白", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	oldExpires := now.Add(time.Duration(params.Grace))
+	tokensLock.Lock()
+	found := false
+	var expires time.Time
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(t.value)) == 1 {
+			tokensLock.Unlock()
+			sendError(w, "new_token与现有token相同", http.StatusBadRequest)
+			return
+		}
+		// grace期间的旧token不是该标签当前的token
+		if t.label == label && !t.rotated && !t.expired(now) {
+			found, expires = true, t.expires
+		}
+	}
+	if !found {
+		tokensLock.Unlock()
+		sendError(w, "未找到有效的token: "+label, http.StatusNotFound)
+		return
+	}
+	// 复制后替换，持有读锁的请求不受影响
+	rotated := make([]authToken, 0, len(tokens)+1)
+	for _, t := range tokens {
+		if t.label == label {
+			if params.Grace == 0 {
+				continue
+			}
+			if t.expires.IsZero() || t.expires.After(oldExpires) {
+				t.expires = oldExpires
+			}
+			t.rotated = true
+		}
+		rotated = append(rotated, t)
+	}
+	tokens = append(rotated, authToken{label: label, value: value, expires: expires})
+	tokensLock.Unlock()
+	// grace已到期的旧token不必等到定期清理
+	removeExpiredTokens(now)
+
+	if params.Grace > 0 {
+		result.OldExpiresAt = oldExpires.Format(timeFormat)
+		result.Message = fmt.Sprintf("token %s已轮换，旧token于%s失效", label, result.OldExpiresAt)
+	} else {
+		result.Message = fmt.Sprintf("token %s已轮换，旧token已失效", label)
+	}
+	if !expires.IsZero() {
+		result.ExpiresAt = expires.In(time.Local).Format(timeFormat)
+		result.Message += "，新token于" + result.ExpiresAt + "过期"
+	}
+	logInfo("%s [操作token:%s]", result.Message, caller)
+	warnShortSecret("token "+label, value)
+	w.Header().Set("Cache-Control", "no-store")
+	sendResponse(w, result, http.StatusOK)
+}
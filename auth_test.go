@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitAllowActions(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags stringList
+		want  []string
+		ok    bool
+	}{
+		{"未指定", nil, nil, true},
+		{"逗号分隔", stringList{"single, list"}, []string{"single", "list"}, true},
+		{"重复指定", stringList{"single", "status,stop"}, []string{"single", "status", "stop"}, true},
+		{"不支持的action", stringList{"single,rm"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &allowActions, tt.flags)
+			err := initAllowActions()
+			if (err == nil) != tt.ok {
+				t.Fatalf("initAllowActions() = %v", err)
+			}
+			if tt.ok && !slices.Equal(allowActions, tt.want) {
+				t.Errorf("allowActions = %v，期望%v", allowActions, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowActions(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "echo ok")
+	tests := []struct {
+		name  string
+		allow stringList
+		query string
+		body  string
+		code  int
+	}{
+		{"GET未指定action时为single", stringList{"single"}, "", "", http.StatusOK},
+		{"GET未启用single", stringList{"list"}, "", "", http.StatusForbidden},
+		{"GET指定启用的action", stringList{"list"}, "action=list", "", http.StatusOK},
+		{"GET指定未启用的action", stringList{"single"}, "action=list", "", http.StatusForbidden},
+		{"POST未指定action时为single", stringList{"single"}, "", `{}`, http.StatusOK},
+		{"POST未启用single", stringList{"list"}, "", `{}`, http.StatusForbidden},
+		{"POST指定启用的action", stringList{"list"}, "", `{"action":"list"}`, http.StatusOK},
+		{"POST指定未启用的action", stringList{"single"}, "", `{"action":"multiple","count":2}`, http.StatusForbidden},
+		{"未指定--allow-actions时全部启用", nil, "", `{"action":"list"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &allowActions, tt.allow)
+			w := serveRequest(t, tt.query, tt.body)
+			if w.Code != tt.code {
+				t.Errorf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func rotateToken(t *testing.T, token, body string) (*httptest.ResponseRecorder, RotateTokenResult) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/endpoint", strings.NewReader(body))
+	r.Header.Set("token", token)
+	w := httptest.NewRecorder()
+	tokenAuthMiddleware(requestHandler)(w, r)
+	var result RotateTokenResult
+	if w.Code == http.StatusOK {
+		decodeJSON(t, w, &result)
+	}
+	return w, result
+}
+
+func TestRotateTokenKeepsExpiry(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		expires time.Time
+	}{
+		{"有过期时间", expires},
+		{"不过期", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTokens(t, authToken{label: "ci", value: "old-token", expires: tt.expires})
+			setGlobal(t, &expiredTokens, nil)
+			w, result := rotateToken(t, "old-token", `{"action":"rotate-token","new_token":"new-token"}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码%d: %s", w.Code, w.Body.String())
+			}
+			got, ok := matchToken("new-token")
+			if !ok || !got.expires.Equal(tt.expires) {
+				t.Errorf("新token的过期时间为%v，期望%v", got.expires, tt.expires)
+			}
+			if (result.ExpiresAt != "") != !tt.expires.IsZero() {
+				t.Errorf("expires_at = %q", result.ExpiresAt)
+			}
+			if _, ok := matchToken("old-token"); ok {
+				t.Error("未指定grace时旧token应立即失效")
+			}
+		})
+	}
+}
+
+// grace到期的旧token被移除，不会留在tokens、expiredTokens中
+func TestRotateTokenPrunesGrace(t *testing.T) {
+	setTokens(t, authToken{label: "ci", value: "token-0"})
+	setGlobal(t, &expiredTokens, nil)
+	current := "token-0"
+	for i := 1; i <= 5; i++ {
+		next := "token-" + strconv.Itoa(i)
+		w, _ := rotateToken(t, current, `{"action":"rotate-token","new_token":"`+next+`","grace":"20ms"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("第%d次轮换失败: %d %s", i, w.Code, w.Body.String())
+		}
+		// grace期间旧token仍然有效
+		if _, ok := matchToken(current); !ok {
+			t.Errorf("grace期间旧token %s应有效", current)
+		}
+		current = next
+		time.Sleep(30 * time.Millisecond)
+	}
+	removeExpiredTokens(time.Now())
+	tokensLock.RLock()
+	defer tokensLock.RUnlock()
+	if len(tokens) != 1 || tokens[0].value != current || len(expiredTokens) != 0 {
+		t.Errorf("tokens = %d个，expiredTokens = %d个，期望只保留当前的token", len(tokens), len(expiredTokens))
+	}
+}
+
+func TestRotateExpiredTokenRejected(t *testing.T) {
+	setTokens(t, authToken{label: "admin", value: "admin-token"}, authToken{label: "ci", value: "ci-token", expires: time.Now().Add(-time.Second)})
+	setGlobal(t, &expiredTokens, nil)
+	w, _ := rotateToken(t, "admin-token", `{"action":"rotate-token","token_label":"ci"}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("轮换已过期的token状态码%d，期望404", w.Code)
+	}
+}
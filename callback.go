@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	callbackWorkers  = 4
+	callbackAttempts = 3
+	callbackTimeout  = 10 * time.Second
+)
+
+// 待发送的回调，队列满时丢弃，不影响命令执行
+type callbackJob struct {
+	url    string
+	result CommandResult
+}
+
+var (
+	callbackQueue  = make(chan callbackJob, 256)
+	callbackClient = &http.Client{Timeout: callbackTimeout, CheckRedirect: checkCallbackRedirect}
+)
+
+// 启动发送回调的协程
+func initCallback() error {
+	for i := 0; i < callbackWorkers; i++ {
+		go callbackWorker()
+	}
+	return nil
+}
+
+// 校验回调地址，主机须在--callback-allow允许的列表中
+func validateCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	if len(callbackAllow) == 0 {
+		return errors.New("服务端未允许回调，需通过--callback-allow指定允许的主机")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的callback_url: %s", raw)
+	}
+	for _, allowed := range callbackAllow {
+		if callbackHostAllowed(u, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback_url的主机不在允许的列表中: %s", u.Host)
+}
+
+// allowed可以是主机名、主机名:端口，或以*.开头匹配子域名
+func callbackHostAllowed(u *url.URL, allowed string) bool {
+	host := strings.ToLower(u.Hostname())
+	allowed = strings.ToLower(allowed)
+	if _, _, err := net.SplitHostPort(allowed); err == nil {
+		return strings.ToLower(u.Host) == allowed
+	}
+	if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == allowed
+}
+
+// 重定向的地址同样须在允许的列表中，否则可借允许的主机跳转到内网地址
+func checkCallbackRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("回调重定向次数过多")
+	}
+	return validateCallbackURL(req.URL.String())
+}
+
+// 将执行结果加入回调队列
+func enqueueCallback(callbackURL string, result CommandResult) {
+	if callbackURL == "" {
+		return
+	}
+	select {
+	case callbackQueue <- callbackJob{url: callbackURL, result: result}:
+	default:
+		logWarn("回调队列已满，丢弃回调 [ExecID:%s]", result.ExecID)
+	}
+}
+
+func callbackWorker() {
+	for job := range callbackQueue {
+		deliverCallback(job)
+	}
+}
+
+// POST执行结果到回调地址，失败时重试
+func deliverCallback(job callbackJob) {
+	body, err := json.Marshal(withIdentity(job.result))
+	if err != nil {
+		return
+	}
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		err = postCallback(job.url, body)
+		if err == nil {
+			return
+		}
+		logWarn("回调失败，第%d次尝试 [ExecID:%s]: %v", attempt, job.result.ExecID, err)
+		if attempt < callbackAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	logError("回调失败，已放弃 [ExecID:%s]: %s", job.result.ExecID, job.url)
+}
+
+func postCallback(callbackURL string, body []byte) error {
+	resp, err := callbackClient.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("响应状态码%d", resp.StatusCode)
+	}
+	return nil
+}
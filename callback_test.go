@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow stringList
+		url   string
+		ok    bool
+	}{
+		{"未设置", nil, "", true},
+		{"未允许回调", nil, "http://example.com/hook", false},
+		{"主机名", stringList{"example.com"}, "https://example.com/hook", true},
+		{"主机名忽略大小写", stringList{"Example.com"}, "https://EXAMPLE.com/hook", true},
+		{"主机名不匹配", stringList{"example.com"}, "https://evil.com/hook", false},
+		{"主机名:端口", stringList{"example.com:8443"}, "https://example.com:8443/hook", true},
+		{"端口不匹配", stringList{"example.com:8443"}, "https://example.com/hook", false},
+		{"子域名", stringList{"*.example.com"}, "https://a.example.com/hook", true},
+		{"通配符不匹配自身", stringList{"*.example.com"}, "https://example.com/hook", false},
+		{"后缀相同的其他域名", stringList{"*.example.com"}, "https://a.badexample.com/hook", false},
+		{"不支持的协议", stringList{"example.com"}, "file://example.com/etc/passwd", false},
+		{"无效地址", stringList{"example.com"}, "://", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &callbackAllow, tt.allow)
+			if err := validateCallbackURL(tt.url); (err == nil) != tt.ok {
+				t.Errorf("validateCallbackURL(%q) = %v", tt.url, err)
+			}
+		})
+	}
+}
+
+// 允许回调到server所在的主机:端口
+func allowCallbackServer(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	u, _ := url.Parse(server.URL)
+	setGlobal(t, &callbackAllow, stringList{u.Host})
+}
+
+func TestDeliverCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32 // 前几次返回500
+		attempts int32
+	}{
+		{"成功", 0, 1},
+		{"失败后重试", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			var got CommandResult
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("%s %s", r.Method, r.Header.Get("Content-Type"))
+				}
+				json.NewDecoder(r.Body).Decode(&got)
+			}))
+			defer server.Close()
+			allowCallbackServer(t, server)
+
+			deliverCallback(callbackJob{url: server.URL + "/hook", result: CommandResult{ExecID: "cb-1", ExitCode: 3}})
+			if n := attempts.Load(); n != tt.attempts {
+				t.Errorf("请求了%d次，期望%d次", n, tt.attempts)
+			}
+			if got.ExecID != "cb-1" || got.ExitCode != 3 || got.Hostname != hostname {
+				t.Errorf("回调内容: %+v", got)
+			}
+		})
+	}
+}
+
+// 允许的主机重定向到未允许的主机时不能跟随
+func TestCallbackRedirect(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+	}))
+	defer internal.Close()
+	var allowedHits atomic.Int32
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowedHits.Add(1)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusTemporaryRedirect)
+		}
+	}))
+	defer allowed.Close()
+	allowCallbackServer(t, allowed)
+
+	tests := []struct {
+		name     string
+		to       string
+		internal int32
+		err      bool
+	}{
+		{"重定向到允许的主机", allowed.URL + "/hook", 0, false},
+		{"重定向到未允许的主机", internal.URL + "/hook", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			internalHits.Store(0)
+			allowedHits.Store(0)
+			err := postCallback(allowed.URL+"/redirect?to="+url.QueryEscape(tt.to), []byte("{}"))
+			if (err != nil) != tt.err {
+				t.Errorf("postCallback() = %v", err)
+			}
+			if n := internalHits.Load(); n != tt.internal {
+				t.Errorf("未允许的主机收到%d次请求", n)
+			}
+			if tt.err {
+				return
+			}
+			if n := allowedHits.Load(); n != 2 {
+				t.Errorf("允许的主机收到%d次请求，期望2次", n)
+			}
+		})
+	}
+}
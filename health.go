@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务启动时间，用于计算运行时长
+var serverStartTime = time.Now()
+
+type HealthStatus struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// 注册健康检查路由，不需要token，也不会执行命令
+func registerHealthRoutes() []string {
+	if !healthEnabled {
+		return nil
+	}
+	path := "/" + strings.TrimPrefix(healthPath, "/")
+	http.HandleFunc(path, handleHealth)
+	ready := "/" + strings.TrimPrefix(readyPath, "/")
+	http.HandleFunc(ready, handleReady)
+	if readinessExec > 0 {
+		// 启动时即开始第一次试运行
+		readinessExecCheck()
+	}
+	return []string{path, ready}
+}
+
+// 健康检查只读取启动时确定的信息，不获取execLock，执行繁忙时也能立即响应
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, HealthStatus{
+		Status:        "ok",
+		Version:       appConfig.Version,
+		UptimeSeconds: int64(time.Since(serverStartTime).Seconds()),
+	}, http.StatusOK)
+}
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// --version-path：与-v输出相同的版本信息，不需要token
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, VersionInfo{
+		Version:   appConfig.Version,
+		Commit:    appConfig.Commit,
+		BuildDate: appConfig.BuildDate,
+	}, http.StatusOK)
+}
+
+// action=info返回的服务信息，不包含token及命令内容
+type ServerInfo struct {
+	Version           string `json:"version"`
+	GOOS              string `json:"goos"`
+	GOARCH            string `json:"goarch"`
+	GoVersion         string `json:"go_version"`
+	Hostname          string `json:"hostname"`
+	Instance          string `json:"instance,omitempty"`
+	PID               int    `json:"pid"`
+	Shell             string `json:"shell"` // 包装命令的shell，--no-shell时为空
+	Timezone          string `json:"timezone"`
+	StartedAt         string `json:"started_at"`
+	UptimeSeconds     int64  `json:"uptime_seconds"`
+	RunningExecutions int    `json:"running_executions"`
+	RSSBytes          int64  `json:"rss_bytes,omitempty"`
+	TokenEnabled      bool   `json:"token_enabled"`
+	ReadOnly          bool   `json:"read_only"`
+	ValidTokens       *int   `json:"valid_tokens,omitempty"` // 未过期的token数量，未启用认证时为空
+	// 服务端启用的action
+	Actions []string `json:"actions"`
+}
+
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	execLock.Lock()
+	running := len(executions)
+	execLock.Unlock()
+
+	name, offset := time.Now().Zone()
+	info := ServerInfo{
+		Version:           appConfig.Version,
+		GOOS:              runtime.GOOS,
+		GOARCH:            runtime.GOARCH,
+		GoVersion:         runtime.Version(),
+		Hostname:          hostname,
+		Instance:          instanceName,
+		PID:               os.Getpid(),
+		Timezone:          fmt.Sprintf("%s (UTC%+03d:%02d)", name, offset/3600, abs(offset%3600)/60),
+		StartedAt:         serverStartTime.In(time.Local).Format(timeFormat),
+		UptimeSeconds:     int64(time.Since(serverStartTime).Seconds()),
+		RunningExecutions: running,
+		RSSBytes:          processRSS(),
+		TokenEnabled:      authEnabled(),
+		ReadOnly:          readOnly,
+		Actions:           enabledActions(),
+	}
+	if authEnabled() {
+		n := validTokenCount()
+		info.ValidTokens = &n
+	}
+	if !noShell {
+		info.Shell = strings.Join(shellArgv, " ")
+	}
+	sendResponse(w, info, http.StatusOK)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type ReadyCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type ReadyStatus struct {
+	Status string       `json:"status"` // ready、not_ready
+	Checks []ReadyCheck `json:"checks"`
+}
+
+// 就绪检查：检查命令能否执行（可执行文件、脚本、工作目录），不实际执行命令
+// 设置--readiness-exec时附加最近一次试运行的结果，任一检查失败返回503
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := readyChecks()
+	if readinessExec > 0 {
+		checks = append(checks, readinessExecCheck())
+	}
+	status := ReadyStatus{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status.Status = "not_ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	sendResponse(w, status, code)
+}
+
+func newReadyCheck(name string, err error) ReadyCheck {
+	if err != nil {
+		return ReadyCheck{Name: name, Error: err.Error()}
+	}
+	return ReadyCheck{Name: name, OK: true}
+}
+
+// 只做文件系统检查，探测频繁时也不会产生明显开销
+func readyChecks() []ReadyCheck {
+	checks := []ReadyCheck{}
+	files, err := readyExecutables()
+	if err != nil {
+		return append(checks, newReadyCheck("command", err))
+	}
+	for _, file := range files {
+		checks = append(checks, newReadyCheck("executable", checkExecutable(file)))
+	}
+	if scriptPath != "" {
+		checks = append(checks, newReadyCheck("script", checkReadable(scriptPath)))
+	}
+	for _, dir := range allowWorkdirs {
+		checks = append(checks, newReadyCheck("workdir", checkDir(dir)))
+	}
+	if outputDir != "" {
+		checks = append(checks, newReadyCheck("output_dir", checkDir(outputDir)))
+	}
+	return checks
+}
+
+// 按命令模板确定要检查的可执行文件，就绪检查没有模板参数的取值，{name}保持原样
+// --no-shell时可执行文件本身是模板参数的无法检查，跳过
+func readyExecutables() ([]string, error) {
+	if scriptPath != "" {
+		return []string{commandArgv(ExecOptions{Argv: scriptInterpreter()})[0]}, nil
+	}
+	var files []string
+	for _, tmpl := range commandSteps {
+		opts := ExecOptions{Command: tmpl}
+		if noShell {
+			argv, err := splitCommandLine(tmpl)
+			if err != nil {
+				return nil, err
+			}
+			opts.Argv = argv
+		}
+		if file := commandArgv(opts)[0]; !placeholderPattern.MatchString(file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func checkExecutable(file string) error {
+	if childPath != "" {
+		file = lookPathIn(file, childPath)
+	}
+	if _, err := exec.LookPath(file); err != nil {
+		return fmt.Errorf("可执行文件不存在或不可执行: %s", file)
+	}
+	return nil
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("文件不可读: %v", err)
+	}
+	return f.Close()
+}
+
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("目录不存在: %s", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("不是目录: %s", dir)
+	}
+	return nil
+}
+
+// --readiness-exec试运行的超时时间
+const readinessExecTimeout = 10 * time.Second
+
+// 最近一次试运行的结果，同一时间最多只有一次试运行
+var readiness struct {
+	sync.Mutex
+	running   bool
+	checkedAt time.Time
+	result    *CommandResult
+}
+
+// 返回缓存的试运行结果，距上次试运行超过--readiness-exec时在后台重新试运行，探测请求不等待
+func readinessExecCheck() ReadyCheck {
+	readiness.Lock()
+	defer readiness.Unlock()
+	if !readiness.running && time.Since(readiness.checkedAt) >= readinessExec {
+		readiness.running = true
+		go runReadinessExec()
+	}
+	switch {
+	case readiness.result == nil:
+		return ReadyCheck{Name: "exec", Error: "命令试运行尚未完成"}
+	case readiness.result.Status != "COMPLETED":
+		return ReadyCheck{Name: "exec", Error: fmt.Sprintf("命令试运行结果为%s（%s）", readiness.result.Status, readiness.result.ExecTime)}
+	}
+	return ReadyCheck{Name: "exec", OK: true}
+}
+
+// 按实际执行配置试运行一次命令，不执行--on-failure，也不记录到执行历史
+func runReadinessExec() {
+	var result CommandResult
+	opts, err := buildExecOptions(RequestParams{})
+	if err != nil {
+		result = CommandResult{Status: "START_FAILED", Error: err.Error(), ExecTime: time.Now().Format(timeFormat)}
+	} else {
+		if opts.Timeout == 0 || opts.Timeout > readinessExecTimeout {
+			opts.Timeout = readinessExecTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), readinessExecTimeout)
+		if len(opts.Steps) > 0 {
+			result = runSteps(ctx, generateID(), opts)
+		} else {
+			result = runCommand(ctx, generateID(), opts)
+		}
+		cancel()
+	}
+	if result.Status != "COMPLETED" {
+		logWarn("就绪检查试运行命令失败: %s", result.Status)
+	}
+	readiness.Lock()
+	readiness.running = false
+	readiness.checkedAt = time.Now()
+	readiness.result = &result
+	readiness.Unlock()
+}
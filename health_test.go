@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReadyWithTemplateParams(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	tests := []struct {
+		name   string
+		cmd    string
+		noSh   bool
+		params map[string]*regexp.Regexp
+		code   int
+	}{
+		{"shell", "echo {name}", false, map[string]*regexp.Regexp{"name": regexp.MustCompile("^[a-z]+$")}, http.StatusOK},
+		{"no-shell", "echo {name}", true, map[string]*regexp.Regexp{"name": regexp.MustCompile("^[a-z]+$")}, http.StatusOK},
+		{"no-shell可执行文件为模板参数", "{bin} x", true, map[string]*regexp.Regexp{"bin": regexp.MustCompile("^[a-z]+$")}, http.StatusOK},
+		{"可执行文件不存在", "remotec_no_such_command {name}", true, map[string]*regexp.Regexp{"name": regexp.MustCompile("^[a-z]+$")}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCommand(t, tt.cmd)
+			setGlobal(t, &noShell, tt.noSh)
+			setGlobal(t, &templateParams, tt.params)
+			w := httptest.NewRecorder()
+			handleReady(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.code {
+				t.Errorf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadinessExecRejectsTemplateParams(t *testing.T) {
+	setCommand(t, "echo {name}")
+	setGlobal(t, &paramDefs, stringList{"name=[a-z]+"})
+	setGlobal(t, &templateParams, map[string]*regexp.Regexp{})
+	setGlobal(t, &readinessExec, time.Minute)
+	if err := initCommandTemplate(); err == nil {
+		t.Error("--readiness-exec与--param同时使用时应启动失败")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 按Idempotency-Key缓存的单次执行响应
+type idempotencyEntry struct {
+	done    chan struct{} // 第一次请求的响应写完后关闭
+	code    int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+var (
+	idempotencyLock  sync.Mutex
+	idempotencyCache = make(map[string]*idempotencyEntry)
+	idempotencyOrder []string // 按加入顺序，超出--idempotency-keys时淘汰最早的已完成记录
+)
+
+// 记录响应内容的ResponseWriter
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// 携带Idempotency-Key的请求只执行一次，重复的请求直接返回第一次请求的响应
+// 第一次请求仍在执行时，按--idempotency-reject返回409或等待其结束
+func handleIdempotent(w http.ResponseWriter, r *http.Request, key string, handle func(http.ResponseWriter)) {
+	entry, first := claimIdempotencyKey(key)
+	if entry == nil {
+		sendError(w, "Idempotency-Key缓存已满且均在执行中，请稍后重试", http.StatusServiceUnavailable)
+		return
+	}
+	if first {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			idempotencyLock.Lock()
+			entry.code = rec.code
+			entry.header = rec.Header().Clone()
+			entry.body = rec.body.Bytes()
+			entry.expires = time.Now().Add(idempotencyTTL)
+			idempotencyLock.Unlock()
+			close(entry.done)
+		}()
+		handle(rec)
+		return
+	}
+
+	select {
+	case <-entry.done:
+	default:
+		if idempotencyReject {
+			sendError(w, "相同Idempotency-Key的请求正在执行中", http.StatusConflict)
+			return
+		}
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	logInfo("重复的请求，返回Idempotency-Key为%s的已有结果", key)
+	idempotencyLock.Lock()
+	code, header, body := entry.code, entry.header, entry.body
+	idempotencyLock.Unlock()
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// 取得key对应的缓存，不存在或已过期时新建并返回true
+// 缓存已满且全部在执行中时返回nil，此时淘汰任何记录都会导致重试时再次执行
+func claimIdempotencyKey(key string) (*idempotencyEntry, bool) {
+	idempotencyLock.Lock()
+	defer idempotencyLock.Unlock()
+	now := time.Now()
+	// 清理过期的记录，执行中的记录expires为空，不会被清理
+	for len(idempotencyOrder) > 0 {
+		oldest := idempotencyCache[idempotencyOrder[0]]
+		if oldest.expires.IsZero() || oldest.expires.After(now) {
+			break
+		}
+		delete(idempotencyCache, idempotencyOrder[0])
+		idempotencyOrder = idempotencyOrder[1:]
+	}
+	if entry, exists := idempotencyCache[key]; exists {
+		if entry.expires.IsZero() || entry.expires.After(now) {
+			return entry, false
+		}
+		removeIdempotencyKey(key)
+	}
+	if len(idempotencyOrder) >= max(idempotencyMaxKeys, 1) && !evictIdempotencyKey() {
+		return nil, false
+	}
+	entry := &idempotencyEntry{done: make(chan struct{})}
+	idempotencyCache[key] = entry
+	idempotencyOrder = append(idempotencyOrder, key)
+	return entry, true
+}
+
+// 淘汰最早的已完成的记录，没有可淘汰的记录时返回false，调用方需持有idempotencyLock
+func evictIdempotencyKey() bool {
+	for _, key := range idempotencyOrder {
+		if !idempotencyCache[key].expires.IsZero() {
+			removeIdempotencyKey(key)
+			return true
+		}
+	}
+	return false
+}
+
+// 调用方需持有idempotencyLock
+func removeIdempotencyKey(key string) {
+	delete(idempotencyCache, key)
+	if i := slices.Index(idempotencyOrder, key); i >= 0 {
+		idempotencyOrder = slices.Delete(idempotencyOrder, i, i+1)
+	}
+}
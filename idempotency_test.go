@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 清空Idempotency-Key缓存，测试结束后恢复
+func resetIdempotency(t *testing.T) {
+	t.Helper()
+	idempotencyLock.Lock()
+	defer idempotencyLock.Unlock()
+	oldCache, oldOrder := idempotencyCache, idempotencyOrder
+	idempotencyCache, idempotencyOrder = make(map[string]*idempotencyEntry), nil
+	t.Cleanup(func() {
+		idempotencyLock.Lock()
+		defer idempotencyLock.Unlock()
+		idempotencyCache, idempotencyOrder = oldCache, oldOrder
+	})
+}
+
+func serveIdempotent(t *testing.T, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/endpoint", strings.NewReader("{}"))
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	requestHandler(w, r)
+	return w
+}
+
+// 每次执行都向文件追加一行，返回执行次数
+func countingCommand(t *testing.T, extra string) func() int {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "runs")
+	setCommand(t, "echo run >> "+file+"; "+extra)
+	return func() int {
+		data, _ := os.ReadFile(file)
+		return strings.Count(string(data), "run\n")
+	}
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	tests := []struct {
+		name   string
+		reject bool
+		codes  map[int]int // 状态码及其数量
+	}{
+		{"等待第一次请求结束", false, map[int]int{http.StatusOK: 5}},
+		{"返回409", true, map[int]int{http.StatusOK: 1, http.StatusConflict: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIdempotency(t)
+			setGlobal(t, &idempotencyReject, tt.reject)
+			runs := countingCommand(t, "sleep 0.5; echo $$")
+
+			var mu sync.Mutex
+			codes := make(map[int]int)
+			bodies := make(map[string]bool)
+			replayed := 0
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w := serveIdempotent(t, "deploy-"+tt.name)
+					mu.Lock()
+					defer mu.Unlock()
+					codes[w.Code]++
+					if w.Code == http.StatusOK {
+						bodies[w.Body.String()] = true
+					}
+					if w.Header().Get("Idempotent-Replayed") == "true" {
+						replayed++
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n := runs(); n != 1 {
+				t.Errorf("命令执行了%d次，期望1次", n)
+			}
+			for code, n := range tt.codes {
+				if codes[code] != n {
+					t.Errorf("状态码%d有%d个，期望%d个: %v", code, codes[code], n, codes)
+				}
+			}
+			if len(bodies) != 1 {
+				t.Errorf("重复的请求应返回相同的响应，实际有%d种", len(bodies))
+			}
+			if want := tt.codes[http.StatusOK] - 1; replayed != want {
+				t.Errorf("Idempotent-Replayed的响应有%d个，期望%d个", replayed, want)
+			}
+			// 第一次请求结束后，重复的请求直接返回缓存的结果
+			if w := serveIdempotent(t, "deploy-"+tt.name); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" {
+				t.Errorf("状态码%d，Idempotent-Replayed=%q", w.Code, w.Header().Get("Idempotent-Replayed"))
+			}
+			if n := runs(); n != 1 {
+				t.Errorf("命令执行了%d次，期望1次", n)
+			}
+		})
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	resetIdempotency(t)
+	setGlobal(t, &idempotencyTTL, 100*time.Millisecond)
+	runs := countingCommand(t, "")
+	serveIdempotent(t, "key")
+	serveIdempotent(t, "key")
+	if n := runs(); n != 1 {
+		t.Fatalf("有效期内命令执行了%d次，期望1次", n)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if w := serveIdempotent(t, "key"); w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("过期后不应返回缓存的结果")
+	}
+	if n := runs(); n != 2 {
+		t.Errorf("过期后命令执行了%d次，期望2次", n)
+	}
+}
+
+func TestIdempotencyBounded(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	resetIdempotency(t)
+	setGlobal(t, &idempotencyMaxKeys, 2)
+	runs := countingCommand(t, "")
+	for _, key := range []string{"a", "b", "c", "a"} {
+		serveIdempotent(t, key)
+	}
+	// 超出上限时淘汰最早的a，再次请求a时重新执行
+	if n := runs(); n != 4 {
+		t.Errorf("命令执行了%d次，期望4次", n)
+	}
+	idempotencyLock.Lock()
+	size, order := len(idempotencyCache), strings.Join(idempotencyOrder, ",")
+	idempotencyLock.Unlock()
+	if size != 2 || order != "c,a" {
+		t.Errorf("缓存了%d个，顺序为%s，期望2个c,a", size, order)
+	}
+}
+
+// 执行中的记录不能被淘汰，否则重试时会再次执行
+func TestIdempotencyKeepsInFlight(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	resetIdempotency(t)
+	setGlobal(t, &idempotencyMaxKeys, 1)
+	runs := countingCommand(t, "sleep 0.5")
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serveIdempotent(t, "in-flight") }()
+	time.Sleep(200 * time.Millisecond)
+
+	if w := serveIdempotent(t, "other"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("缓存已满且均在执行中时状态码%d，期望503", w.Code)
+	}
+	if w := serveIdempotent(t, "in-flight"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("执行中的请求的重试应等待并返回同一结果，状态码%d", w.Code)
+	}
+	<-first
+	if n := runs(); n != 1 {
+		t.Errorf("命令执行了%d次，期望1次", n)
+	}
+	// 第一次请求结束后可以淘汰
+	if w := serveIdempotent(t, "other"); w.Code != http.StatusOK {
+		t.Errorf("状态码%d，期望200", w.Code)
+	}
+}
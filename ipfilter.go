@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+var (
+	allowIPFlags      stringList // --allow-ip，可重复指定或以逗号分隔，单个IP或CIDR
+	trustedProxyFlags stringList // --trusted-proxy，来自这些地址的请求按X-Forwarded-For、X-Real-IP识别客户端IP
+	allowIPs          []netip.Prefix
+	trustedProxies    []netip.Prefix
+)
+
+// 拒绝请求的日志最多每10秒输出一次
+var denyLog = &throttledLog{interval: 10 * time.Second}
+
+// 解析单个IP或CIDR，单个IP按/32或/128处理
+func parsePrefixes(flagName string, values stringList) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range values {
+		for _, s := range strings.Split(item, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if strings.Contains(s, "/") {
+				prefix, err := netip.ParsePrefix(s)
+				if err != nil {
+					return nil, fmt.Errorf("无效的%s: %s", flagName, s)
+				}
+				prefixes = append(prefixes, prefix.Masked())
+				continue
+			}
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("无效的%s: %s", flagName, s)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes, nil
+}
+
+// 解析--allow-ip、--trusted-proxy，格式错误时启动失败
+func initAllowIPs() (err error) {
+	if allowIPs, err = parsePrefixes("--allow-ip", allowIPFlags); err != nil {
+		return err
+	}
+	if trustedProxies, err = parsePrefixes("--trusted-proxy", trustedProxyFlags); err != nil {
+		return err
+	}
+	if len(allowIPs) > 0 {
+		logInfo("允许访问的IP：%s", prefixList(allowIPs))
+	}
+	if len(trustedProxies) > 0 {
+		logInfo("信任的代理：%s，客户端IP取自X-Forwarded-For、X-Real-IP", prefixList(trustedProxies))
+	}
+	return nil
+}
+
+func prefixList(prefixes []netip.Prefix) string {
+	items := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		items[i] = p.String()
+	}
+	return strings.Join(items, ",")
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// 请求的客户端IP：来自信任的代理时取X-Forwarded-For中最右侧的非代理地址，或X-Real-IP
+func clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, ok := parseAddr(host)
+	if !ok || !containsAddr(trustedProxies, remote) {
+		return remote
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseAddr(hops[i])
+			if !ok {
+				break
+			}
+			if !containsAddr(trustedProxies, addr) {
+				return addr
+			}
+		}
+	}
+	if addr, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+		return addr
+	}
+	return remote
+}
+
+// 在所有路由之前校验客户端IP，不在--allow-ip中时返回403
+func allowIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := clientIP(r)
+		if addr.IsValid() && containsAddr(allowIPs, addr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		denyLog.warn("拒绝来自%s的请求，不在--allow-ip中", addr)
+		sendError(w, "不允许的IP", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 一次性token，只保存token的sha256，不保存明文
+type OneTimeToken struct {
+	Label     string    `json:"label"`
+	Hash      string    `json:"hash"`
+	Actions   []string  `json:"actions,omitempty"` // 允许的action，为空时不限制
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SpentAt   time.Time `json:"spent_at"`
+}
+
+const (
+	maxOneTimeTokens     = 1000
+	defaultOneTimeTTL    = time.Hour
+	maxOneTimeTTL        = 7 * 24 * time.Hour
+	oneTimeTokenLifetime = 24 * time.Hour // 已使用、已过期的一次性token保留的时长，期间仍可返回准确的认证失败原因
+)
+
+var (
+	oneTimeLock   sync.Mutex
+	oneTimeTokens = make(map[string]*OneTimeToken) // key为token的sha256
+	oneTimeBucket = []byte("onetime")
+)
+
+// 请求使用的一次性token，用于限制action
+const oneTimeTokenKey contextKey = "one_time_token"
+
+var (
+	errOneTimeSpent   = errors.New("一次性token已使用")
+	errOneTimeExpired = errors.New("一次性token已过期")
+)
+
+func oneTimeHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// 校验一次性token是否可用，不标记为已使用，未找到时返回nil
+func checkOneTimeToken(value string) (*OneTimeToken, error) {
+	if value == "" {
+		return nil, nil
+	}
+	oneTimeLock.Lock()
+	defer oneTimeLock.Unlock()
+	t, ok := oneTimeTokens[oneTimeHash(value)]
+	if !ok {
+		return nil, nil
+	}
+	return t, oneTimeTokenUsableLocked(t, time.Now())
+}
+
+func oneTimeTokenUsableLocked(t *OneTimeToken, now time.Time) error {
+	if !t.SpentAt.IsZero() {
+		return errOneTimeSpent
+	}
+	if !now.Before(t.ExpiresAt) {
+		return errOneTimeExpired
+	}
+	return nil
+}
+
+// 标记一次性token为已使用，并发的请求中只有一个成功
+func spendOneTimeToken(t *OneTimeToken) error {
+	oneTimeLock.Lock()
+	defer oneTimeLock.Unlock()
+	now := time.Now()
+	if err := oneTimeTokenUsableLocked(t, now); err != nil {
+		return err
+	}
+	t.SpentAt = now
+	saveOneTimeToken(t)
+	return nil
+}
+
+// 请求使用一次性token时在分发执行前标记为已使用，失败时返回403
+// action不允许、参数无效的请求在此之前已被拒绝，不会消耗一次性token
+func spendRequestToken(w http.ResponseWriter, r *http.Request) bool {
+	t := requestOneTimeToken(r)
+	if t == nil {
+		return true
+	}
+	if err := spendOneTimeToken(t); err != nil {
+		logWarn("一次性token %s不可用: %v", t.Label, err)
+		sendError(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	logInfo("一次性token %s已使用", t.Label)
+	return true
+}
+
+// 请求使用一次性token时返回该token
+func requestOneTimeToken(r *http.Request) *OneTimeToken {
+	t, _ := r.Context().Value(oneTimeTokenKey).(*OneTimeToken)
+	return t
+}
+
+// 一次性token认证，已使用或已过期时返回403及原因，通过时由spendRequestToken标记为已使用
+func oneTimeTokenAuth(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) bool {
+	t, err := checkOneTimeToken(r.Header.Get("token"))
+	if t == nil {
+		return false
+	}
+	switch err {
+	case errOneTimeSpent:
+		logWarn("认证失败，一次性token %s已于%s使用", t.Label, t.SpentAt.In(time.Local).Format(timeFormat))
+		sendError(w, err.Error(), http.StatusForbidden)
+	case errOneTimeExpired:
+		logWarn("认证失败，一次性token %s已于%s过期", t.Label, t.ExpiresAt.In(time.Local).Format(timeFormat))
+		sendError(w, err.Error(), http.StatusForbidden)
+	default:
+		logInfo("认证通过 [token:%s]", t.Label)
+		ctx := context.WithValue(r.Context(), tokenLabelKey, t.Label)
+		next(w, r.WithContext(context.WithValue(ctx, oneTimeTokenKey, t)))
+	}
+	return true
+}
+
+// 写入数据文件，未设置--data-dir时只保存在内存中
+func saveOneTimeToken(t *OneTimeToken) {
+	if store == nil {
+		return
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	// 直接写入而不经过后台队列，避免服务异常退出后已使用的token再次生效
+	err = store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(oneTimeBucket).Put([]byte(t.Hash), data)
+	})
+	if err != nil {
+		logWarn("写入一次性token失败: %v", err)
+	}
+}
+
+func deleteOneTimeTokens(hashes []string) {
+	if store == nil || len(hashes) == 0 {
+		return
+	}
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(oneTimeBucket)
+		for _, hash := range hashes {
+			if err := b.Delete([]byte(hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logWarn("删除一次性token失败: %v", err)
+	}
+}
+
+// 从数据文件恢复一次性token
+func restoreOneTimeTokens() error {
+	oneTimeLock.Lock()
+	defer oneTimeLock.Unlock()
+	err := store.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(oneTimeBucket).ForEach(func(k, v []byte) error {
+			var t OneTimeToken
+			if err := json.Unmarshal(v, &t); err == nil {
+				oneTimeTokens[t.Hash] = &t
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	pruneOneTimeTokensLocked(time.Now())
+	return nil
+}
+
+// 移除已使用或已过期超过保留时长的一次性token
+func pruneOneTimeTokens(now time.Time) {
+	oneTimeLock.Lock()
+	defer oneTimeLock.Unlock()
+	pruneOneTimeTokensLocked(now)
+}
+
+func pruneOneTimeTokensLocked(now time.Time) {
+	var removed []string
+	for hash, t := range oneTimeTokens {
+		end := t.ExpiresAt
+		if !t.SpentAt.IsZero() && t.SpentAt.Before(end) {
+			end = t.SpentAt
+		}
+		if now.Sub(end) > oneTimeTokenLifetime {
+			delete(oneTimeTokens, hash)
+			removed = append(removed, hash)
+		}
+	}
+	deleteOneTimeTokens(removed)
+}
+
+// 达到数量上限时移除最早过期的已使用或已过期的token，返回是否有空位
+func evictOneTimeTokensLocked(now time.Time) bool {
+	pruneOneTimeTokensLocked(now)
+	if len(oneTimeTokens) < maxOneTimeTokens {
+		return true
+	}
+	var done []*OneTimeToken
+	for _, t := range oneTimeTokens {
+		if !t.SpentAt.IsZero() || !now.Before(t.ExpiresAt) {
+			done = append(done, t)
+		}
+	}
+	if len(done) == 0 {
+		return false
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].ExpiresAt.Before(done[j].ExpiresAt) })
+	delete(oneTimeTokens, done[0].Hash)
+	deleteOneTimeTokens([]string{done[0].Hash})
+	return true
+}
+
+// action=mint-token的响应，token只返回这一次
+type MintTokenResult struct {
+	Label     string   `json:"label"`
+	Token     string   `json:"token"`
+	ExpiresAt string   `json:"expires_at"`
+	Actions   []string `json:"actions,omitempty"`
+}
+
+// 生成一次性token：使用一次后失效，可限制有效期及允许的action
+func handleMintToken(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if !authEnabled() {
+		sendError(w, "未设置token，无法生成一次性token", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		sendError(w, "生成一次性token需使用POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := requestTokenLabel(r)
+	if !isAdminRequest(r) {
+		logWarn("token %s没有生成一次性token的权限", caller)
+		sendError(w, fmt.Sprintf("token（%s）没有生成一次性token的权限，仅未限制--role的token可以生成", caller), http.StatusForbidden)
+		return
+	}
+	ttl := time.Duration(params.TTL)
+	if ttl == 0 {
+		ttl = defaultOneTimeTTL
+	}
+	if ttl < 0 || ttl > maxOneTimeTTL {
+		sendError(w, fmt.Sprintf("ttl须在0到%s之间", maxOneTimeTTL), http.StatusBadRequest)
+		return
+	}
+	var actions []string
+	for _, action := range params.TokenActions {
+		if !slices.Contains(actionNames, action) {
+			sendError(w, "token_actions中不支持的action: "+action, http.StatusBadRequest)
+			return
+		}
+		if action == "mint-token" || action == "rotate-token" {
+			sendError(w, "一次性token不能用于action="+action, http.StatusBadRequest)
+			return
+		}
+		actions = append(actions, action)
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		sendError(w, "生成token失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	value := hex.EncodeToString(buf)
+	now := time.Now()
+	t := &OneTimeToken{
+		Hash:      oneTimeHash(value),
+		Actions:   actions,
+		CreatedBy: caller,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	t.Label = "once-" + t.Hash[:8]
+
+	oneTimeLock.Lock()
+	if !evictOneTimeTokensLocked(now) {
+		oneTimeLock.Unlock()
+		sendError(w, fmt.Sprintf("未使用的一次性token已达上限%d个", maxOneTimeTokens), http.StatusTooManyRequests)
+		return
+	}
+	oneTimeTokens[t.Hash] = t
+	saveOneTimeToken(t)
+	oneTimeLock.Unlock()
+
+	result := MintTokenResult{
+		Label:     t.Label,
+		Token:     value,
+		ExpiresAt: t.ExpiresAt.Format(timeFormat),
+		Actions:   actions,
+	}
+	logInfo("已生成一次性token %s，%s过期 [操作token:%s]", t.Label, result.ExpiresAt, caller)
+	w.Header().Set("Cache-Control", "no-store")
+	sendResponse(w, result, http.StatusOK)
+}
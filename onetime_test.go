@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 启用管理token admin-token，清空一次性token
+func setupOneTimeTokens(t *testing.T) {
+	t.Helper()
+	setTokens(t, authToken{label: "admin", value: "admin-token"})
+	setGlobal(t, &expiredTokens, nil)
+	setGlobal(t, &oneTimeTokens, make(map[string]*OneTimeToken))
+}
+
+// 以token请求端点，经过token认证
+func serveWithToken(t *testing.T, token, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := tokenAuthMiddleware(requestHandler)
+	if strings.HasSuffix(path, "/openapi.json") {
+		handler = tokenAuthMiddleware(openAPIHandler("endpoint"))
+	}
+	method := http.MethodGet
+	if body != "" {
+		method = http.MethodPost
+	}
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("token", token)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func mintToken(t *testing.T, body string) string {
+	t.Helper()
+	w := serveWithToken(t, "admin-token", "/endpoint", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("生成一次性token失败: %d %s", w.Code, w.Body.String())
+	}
+	var result MintTokenResult
+	decodeJSON(t, w, &result)
+	return result.Token
+}
+
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Error string `json:"error"`
+	}
+	decodeJSON(t, w, &resp)
+	return resp.Error
+}
+
+func TestOneTimeTokenSingleUse(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setupOneTimeTokens(t)
+	runs := countingCommand(t, "")
+	token := mintToken(t, `{"action":"mint-token"}`)
+
+	if w := serveWithToken(t, token, "/endpoint", `{}`); w.Code != http.StatusOK {
+		t.Fatalf("第一次使用状态码%d: %s", w.Code, w.Body.String())
+	}
+	w := serveWithToken(t, token, "/endpoint", `{}`)
+	if w.Code != http.StatusForbidden || errorMessage(t, w) != errOneTimeSpent.Error() {
+		t.Errorf("再次使用: %d %s", w.Code, w.Body.String())
+	}
+	if n := runs(); n != 1 {
+		t.Errorf("命令执行了%d次，期望1次", n)
+	}
+}
+
+func TestOneTimeTokenConcurrentUse(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setupOneTimeTokens(t)
+	runs := countingCommand(t, "")
+	token := mintToken(t, `{"action":"mint-token"}`)
+
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serveWithToken(t, token, "/endpoint", `{}`)
+			mu.Lock()
+			defer mu.Unlock()
+			codes[w.Code]++
+		}()
+	}
+	wg.Wait()
+	if codes[http.StatusOK] != 1 || codes[http.StatusForbidden] != 9 {
+		t.Errorf("状态码: %v，期望一个200、其余403", codes)
+	}
+	if n := runs(); n != 1 {
+		t.Errorf("命令执行了%d次，期望1次", n)
+	}
+}
+
+func TestOneTimeTokenExpired(t *testing.T) {
+	setupOneTimeTokens(t)
+	token := mintToken(t, `{"action":"mint-token","ttl":"50ms"}`)
+	time.Sleep(100 * time.Millisecond)
+	w := serveWithToken(t, token, "/endpoint?action=list", "")
+	if w.Code != http.StatusForbidden || errorMessage(t, w) != errOneTimeExpired.Error() {
+		t.Errorf("过期后使用: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// 被拒绝或未执行的请求不消耗一次性token
+func TestOneTimeTokenNotSpentOnRejection(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"action不允许", "/endpoint?action=list", "", http.StatusForbidden},
+		{"无效的JSON", "/endpoint", `{"action":`, http.StatusBadRequest},
+		{"无效的exec_id", "/endpoint", `{"exec_id":"a b"}`, http.StatusBadRequest},
+		{"无效的参数", "/endpoint", `{"args":["x"]}`, http.StatusBadRequest},
+		{"试运行", "/endpoint", `{"dry_run":true}`, http.StatusOK},
+		{"OpenAPI文档", "/endpoint/openapi.json", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOneTimeTokens(t)
+			setGlobal(t, &allowArgs, false)
+			runs := countingCommand(t, "")
+			token := mintToken(t, `{"action":"mint-token","token_actions":["single"]}`)
+			if w := serveWithToken(t, token, tt.path, tt.body); w.Code != tt.code {
+				t.Fatalf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+			if n := runs(); n != 0 {
+				t.Fatalf("命令执行了%d次", n)
+			}
+			if w := serveWithToken(t, token, "/endpoint", `{}`); w.Code != http.StatusOK {
+				t.Errorf("被拒绝的请求消耗了一次性token: %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOneTimeTokenActions(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "true")
+	tests := []struct {
+		name    string
+		actions string
+		path    string
+		body    string
+		code    int
+	}{
+		{"允许的action", `["list"]`, "/endpoint?action=list", "", http.StatusOK},
+		{"未允许的action", `["list"]`, "/endpoint", `{}`, http.StatusForbidden},
+		{"未指定action时为single", `["single"]`, "/endpoint", `{}`, http.StatusOK},
+		{"不限制action", `[]`, "/endpoint?action=history", "", http.StatusOK},
+		{"不能生成一次性token", `[]`, "/endpoint", `{"action":"mint-token"}`, http.StatusForbidden},
+		{"不能轮换token", `[]`, "/endpoint", `{"action":"rotate-token"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOneTimeTokens(t)
+			token := mintToken(t, `{"action":"mint-token","token_actions":`+tt.actions+`}`)
+			if w := serveWithToken(t, token, tt.path, tt.body); w.Code != tt.code {
+				t.Errorf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOneTimeTokensBounded(t *testing.T) {
+	setupOneTimeTokens(t)
+	now := time.Now()
+	for i := 0; i < maxOneTimeTokens; i++ {
+		hash := oneTimeHash("token-" + strconv.Itoa(i))
+		oneTimeTokens[hash] = &OneTimeToken{Hash: hash, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	}
+	// 均未使用时不能再生成
+	w := serveWithToken(t, "admin-token", "/endpoint", `{"action":"mint-token"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("达到上限时状态码%d，期望429", w.Code)
+	}
+	// 已使用的token可被淘汰
+	spent := oneTimeHash("token-0")
+	oneTimeTokens[spent].SpentAt = now
+	mintToken(t, `{"action":"mint-token"}`)
+	if _, ok := oneTimeTokens[spent]; ok || len(oneTimeTokens) != maxOneTimeTokens {
+		t.Errorf("淘汰后共%d个，已使用的token仍存在=%v", len(oneTimeTokens), ok)
+	}
+}
+
+// 设置--data-dir时，已使用的token重启后仍为已使用，未使用的仍可使用
+func TestOneTimeTokensPersisted(t *testing.T) {
+	setupOneTimeTokens(t)
+	path := filepath.Join(t.TempDir(), "remotec.db")
+	db, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &store, db)
+
+	used := mintToken(t, `{"action":"mint-token","token_actions":["list"]}`)
+	unused := mintToken(t, `{"action":"mint-token","token_actions":["list"]}`)
+	if w := serveWithToken(t, used, "/endpoint?action=list", ""); w.Code != http.StatusOK {
+		t.Fatalf("状态码%d: %s", w.Code, w.Body.String())
+	}
+
+	// 模拟重启：关闭数据文件，清空内存中的token后重新打开并恢复
+	db.Close()
+	oneTimeTokens = make(map[string]*OneTimeToken)
+	if store, err = openStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := restoreOneTimeTokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveWithToken(t, used, "/endpoint?action=list", "")
+	if w.Code != http.StatusForbidden || errorMessage(t, w) != errOneTimeSpent.Error() {
+		t.Errorf("重启后已使用的token: %d %s", w.Code, w.Body.String())
+	}
+	if w := serveWithToken(t, unused, "/endpoint?action=list", ""); w.Code != http.StatusOK {
+		t.Errorf("重启后未使用的token: %d %s", w.Code, w.Body.String())
+	}
+}
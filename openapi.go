@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 支持的action取值，单次执行为默认的single
+var actionNames = []string{
+	"single", "multiple", "loop", "at", "stop", "stopAll", "list", "schedules", "stream", "attach",
+	"wait", "info", "stats", "validate", "shutdown", "rotate-token", "mint-token", "status", "result", "history", "export", "pause", "resume", "update", "trigger",
+}
+
+// 只能通过POST传递的参数，不列入GET的查询参数
+var postOnlyParams = []string{"token_label", "new_token", "grace", "ttl", "token_actions"}
+
+type jsonObject = map[string]interface{}
+
+// 根据Go类型生成JSON Schema，结构体登记到components中并以$ref引用
+type schemaBuilder struct {
+	schemas jsonObject
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) jsonObject {
+	switch t {
+	case reflect.TypeOf(DurationParam(0)):
+		return jsonObject{"oneOf": []jsonObject{{"type": "number"}, {"type": "string"}}, "description": "秒数或时长字符串，如500ms、2m30s"}
+	case reflect.TypeOf(JitterParam{}):
+		return jsonObject{"oneOf": []jsonObject{{"type": "number"}, {"type": "string"}}, "description": "秒数、时长字符串或间隔的百分比，如20%"}
+	case reflect.TypeOf(TimeParam{}):
+		return jsonObject{"oneOf": []jsonObject{{"type": "string", "format": "date-time"}, {"type": "integer"}}, "description": "RFC3339时间或Unix时间戳（秒）"}
+	case reflect.TypeOf(time.Time{}):
+		return jsonObject{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if _, exists := b.schemas[t.Name()]; !exists {
+			// 先占位，避免递归引用自身时无限展开
+			b.schemas[t.Name()] = jsonObject{}
+			b.schemas[t.Name()] = jsonObject{"type": "object", "properties": b.properties(t)}
+		}
+		return jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return jsonObject{}
+}
+
+// 按json标签列出结构体的字段，匿名嵌入的结构体字段展开到外层
+func (b *schemaBuilder) properties(t reflect.Type) jsonObject {
+	props := jsonObject{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			for k, v := range b.properties(field.Type) {
+				props[k] = v
+			}
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		props[name] = b.schema(field.Type)
+	}
+	return props
+}
+
+// GET请求的查询参数，与POST的JSON body字段相同，env、params以KEY=VALUE形式重复传递
+func (b *schemaBuilder) queryParameters() []jsonObject {
+	var params []jsonObject
+	t := reflect.TypeOf(RequestParams{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if slices.Contains(postOnlyParams, name) {
+			continue
+		}
+		schema := b.schema(t.Field(i).Type)
+		switch {
+		case name == "action":
+			schema = jsonObject{"type": "string", "enum": enabledActions(), "default": "single"}
+		case t.Field(i).Type.Kind() == reflect.Map:
+			schema = jsonObject{"type": "array", "items": jsonObject{"type": "string"}, "description": "KEY=VALUE形式，可重复传递"}
+		}
+		param := jsonObject{"name": name, "in": "query", "schema": schema}
+		if schema["type"] == "array" {
+			param["style"], param["explode"] = "form", true
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+func jsonContent(schema jsonObject) jsonObject {
+	return jsonObject{"application/json": jsonObject{"schema": schema}}
+}
+
+func response(desc string, schema jsonObject) jsonObject {
+	return jsonObject{"description": desc, "content": jsonContent(schema)}
+}
+
+// 生成描述端点的OpenAPI 3文档，端点路径及认证方式按当前的运行配置生成
+func openAPISpec(endpointPath string) jsonObject {
+	b := &schemaBuilder{schemas: jsonObject{
+		"Error": jsonObject{
+			"type":       "object",
+			"properties": jsonObject{"error": jsonObject{"type": "string"}, "hostname": jsonObject{"type": "string"}},
+		},
+	}}
+	errorRef := jsonObject{"$ref": "#/components/schemas/Error"}
+	result := b.schema(reflect.TypeOf(CommandResult{}))
+	// 不同action的响应结构不同，执行类action返回CommandResult
+	anyResult := jsonObject{"oneOf": []jsonObject{
+		result,
+		b.schema(reflect.TypeOf(ExecutionStatus{})),
+		b.schema(reflect.TypeOf([]ExecutionInfo{})),
+		b.schema(reflect.TypeOf([]ScheduleInfo{})),
+		b.schema(reflect.TypeOf([]HistoryEntry{})),
+		b.schema(reflect.TypeOf([]CommandResult{})),
+		b.schema(reflect.TypeOf(StopAllResult{})),
+		b.schema(reflect.TypeOf(UpdateResult{})),
+		b.schema(reflect.TypeOf(ServerInfo{})),
+		b.schema(reflect.TypeOf(StatsResult{})),
+		b.schema(reflect.TypeOf(ValidateResult{})),
+	}}
+	responses := jsonObject{
+		"200": response("执行结果或查询结果", anyResult),
+		"202": response("异步执行已开始或执行尚未结束", anyResult),
+		"400": response("参数无效", errorRef),
+		"404": response("exec_id不存在", errorRef),
+		"409": response("执行状态冲突", errorRef),
+	}
+	if authEnabled() {
+		responses["403"] = response("token不正确", errorRef)
+	}
+	paramsRef := b.schema(reflect.TypeOf(RequestParams{}))
+
+	base := "/" + endpointPath
+	paths := jsonObject{
+		base: jsonObject{
+			"get": jsonObject{
+				"summary":    "执行命令或管理执行，由action参数决定",
+				"parameters": b.queryParameters(),
+				"responses":  responses,
+			},
+			"post": jsonObject{
+				"summary":     "执行命令或管理执行，参数放入JSON body",
+				"requestBody": jsonObject{"required": true, "content": jsonContent(paramsRef)},
+				"responses":   responses,
+			},
+		},
+	}
+	if restMode {
+		idParam := []jsonObject{{"name": "id", "in": "path", "required": true, "schema": jsonObject{"type": "string"}}}
+		paths[base+"/v1/executions"] = jsonObject{
+			"post": jsonObject{
+				"summary":     "创建执行，action为single、multiple、loop、at",
+				"requestBody": jsonObject{"required": true, "content": jsonContent(paramsRef)},
+				"responses":   responses,
+			},
+			"get":    jsonObject{"summary": "执行列表，同action=list", "responses": responses},
+			"delete": jsonObject{"summary": "停止全部执行，同action=stopAll", "responses": responses},
+		}
+		paths[base+"/v1/executions/{id}"] = jsonObject{
+			"get":    jsonObject{"summary": "执行状态，同action=status", "parameters": idParam, "responses": responses},
+			"delete": jsonObject{"summary": "停止执行，同action=stop", "parameters": idParam, "responses": responses},
+		}
+	}
+
+	spec := jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "remotec",
+			"version": appConfig.Version,
+		},
+		"paths":      paths,
+		"components": jsonObject{"schemas": b.schemas},
+	}
+	if authEnabled() {
+		spec["components"].(jsonObject)["securitySchemes"] = jsonObject{
+			"token": jsonObject{"type": "apiKey", "in": "header", "name": "token"},
+		}
+		spec["security"] = []jsonObject{{"token": []string{}}}
+	}
+	return spec
+}
+
+func openAPIHandler(endpointPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			sendError(w, "方法不允许", http.StatusMethodNotAllowed)
+			return
+		}
+		// 一次性token只用于执行action，不能在这里被反复使用
+		if requestOneTimeToken(r) != nil {
+			sendError(w, "一次性token不能用于获取OpenAPI文档", http.StatusForbidden)
+			return
+		}
+		sendResponse(w, openAPISpec(endpointPath), http.StatusOK)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/getkin/kin-openapi/openapi3"
+	"testing"
+)
+
+func TestOpenAPISpecValid(t *testing.T) {
+	tests := []struct {
+		name string
+		auth bool
+		rest bool
+	}{
+		{"默认", false, false},
+		{"token认证", true, false},
+		{"RESTful路由", false, true},
+		{"token认证及RESTful路由", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &authRequired, tt.auth)
+			setGlobal(t, &restMode, tt.rest)
+			setGlobal(t, &appConfig.Version, "1.0.0")
+			data, err := json.Marshal(openAPISpec("run"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := openapi3.NewLoader().LoadFromData(data)
+			if err != nil {
+				t.Fatalf("解析OpenAPI文档失败: %v", err)
+			}
+			if err := doc.Validate(context.Background()); err != nil {
+				t.Fatalf("OpenAPI文档无效: %v", err)
+			}
+			if doc.Paths.Find("/run") == nil {
+				t.Error("缺少端点路径/run")
+			}
+			if got := doc.Paths.Find("/run/v1/executions/{id}") != nil; got != tt.rest {
+				t.Errorf("RESTful路由存在=%v，期望%v", got, tt.rest)
+			}
+			if got := len(doc.Security) > 0; got != tt.auth {
+				t.Errorf("security存在=%v，期望%v", got, tt.auth)
+			}
+		})
+	}
+}
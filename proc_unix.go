@@ -0,0 +1,211 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 执行命令使用的用户凭据，由--run-as指定
+var runAsCredential *syscall.Credential
+
+// 将命令放入独立的进程组，便于停止时结束整个进程树
+func setupProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// 向进程组发送SIGTERM，允许命令自行清理后退出
+func terminateProcessGroup(pid int) error {
+	return signalProcessGroup(pid, syscall.SIGTERM)
+}
+
+// 向进程组发送SIGKILL强制结束
+func killProcessGroup(pid int) error {
+	return signalProcessGroup(pid, syscall.SIGKILL)
+}
+
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// 非windows平台没有控制台代码页
+func consoleCodePage() uint32 {
+	return 0
+}
+
+// 解析--run-as指定的用户和用户组，并确认当前进程有权限切换
+func initRunAs() error {
+	if runAs == "" {
+		return nil
+	}
+	name, groupName, _ := strings.Cut(runAs, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("--run-as指定的用户不存在: %s", name)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("--run-as指定的用户组不存在: %s", groupName)
+		}
+		gid, _ = strconv.ParseUint(g.Gid, 10, 32)
+	}
+
+	euid := os.Geteuid()
+	if euid != 0 && uint64(euid) != uid {
+		return fmt.Errorf("当前用户无权限以%s身份执行命令，请以root运行", name)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: euid != 0}
+	if euid == 0 && groupName == "" {
+		// 未指定用户组时沿用该用户的附加组
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(n))
+				}
+			}
+		}
+	}
+	runAsCredential = cred
+	logInfo("命令将以用户%s执行 [uid:%d gid:%d]", runAs, cred.Uid, cred.Gid)
+	return nil
+}
+
+// 以--run-as指定的用户身份执行命令
+func applyRunAs(cmd *exec.Cmd) {
+	if runAsCredential != nil {
+		cmd.SysProcAttr.Credential = runAsCredential
+	}
+}
+
+// 通过nice、ionice包装命令以降低执行优先级，子进程均会继承
+func setupPriority() error {
+	if niceLevel != 0 {
+		if _, err := exec.LookPath("nice"); err != nil {
+			return errors.New("未找到nice命令，无法设置--nice")
+		}
+		commandPrefix = append(commandPrefix, "nice", "-n", strconv.Itoa(niceLevel))
+	}
+	if ioniceClass != 0 {
+		if runtime.GOOS != "linux" {
+			return errors.New("--ionice-class仅支持linux平台")
+		}
+		if _, err := exec.LookPath("ionice"); err != nil {
+			return errors.New("未找到ionice命令，无法设置--ionice-class")
+		}
+		commandPrefix = append(commandPrefix, "ionice", "-c", strconv.Itoa(ioniceClass))
+	}
+	return nil
+}
+
+func afterStart(cmd *exec.Cmd) {}
+
+// 内存不足时常见的错误输出
+var outOfMemoryPattern = regexp.MustCompile(`(?i)cannot allocate memory|out of memory|memory exhausted|bad_alloc|MemoryError`)
+
+// 判断命令是否因超出--limit-cpu-seconds或--limit-mem被结束，
+// 命令由shell派生执行时退出码为128+信号值
+func limitExceededStatus(state *os.ProcessState, exitCode int, output string) string {
+	if state == nil {
+		return ""
+	}
+	sig := syscall.Signal(-1)
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		sig = ws.Signal()
+	} else if exitCode > 128 {
+		sig = syscall.Signal(exitCode - 128)
+	}
+	if limitCPU > 0 && (sig == syscall.SIGXCPU || sig == syscall.SIGKILL) {
+		return "KILLED_CPU"
+	}
+	if limitMem > 0 && exitCode != 0 {
+		switch sig {
+		case syscall.SIGSEGV, syscall.SIGABRT, syscall.SIGKILL:
+			return "KILLED_OOM"
+		}
+		if outOfMemoryPattern.MatchString(output) {
+			return "KILLED_OOM"
+		}
+	}
+	return ""
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+}
+
+// 进程被信号终止时返回信号名，正常退出时返回空
+func terminationSignal(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	return signalName(int(ws.Signal()))
+}
+
+func signalName(sig int) string {
+	if name, ok := signalNames[syscall.Signal(sig)]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", sig)
+}
+
+// 进程当前占用的物理内存（字节），linux以外的平台为峰值，获取失败时为0
+func processRSS() int64 {
+	if runtime.GOOS == "linux" {
+		data, err := os.ReadFile("/proc/self/statm")
+		if err != nil {
+			return 0
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) < 2 {
+			return 0
+		}
+		pages, _ := strconv.ParseInt(fields[1], 10, 64)
+		return pages * int64(os.Getpagesize())
+	}
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	// darwin的单位为字节，其他平台为KB
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
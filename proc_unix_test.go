@@ -0,0 +1,146 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 启动命令，等待其运行一段时间后取消，返回执行结果
+func runAndCancel(t *testing.T, opts ExecOptions, after time.Duration) CommandResult {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan CommandResult, 1)
+	go func() { done <- executeCommand(ctx, generateID(), opts) }()
+	time.Sleep(after)
+	cancel()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("取消后命令未结束")
+		return CommandResult{}
+	}
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	setGlobal(t, &killGrace, time.Second)
+	tests := []struct {
+		name    string
+		command string
+	}{
+		{"后台子进程", "sleep 60 & echo $!; sleep 60"},
+		{"嵌套shell", "sh -c 'sleep 60 & echo $!; wait' & wait"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := runAndCancel(t, ExecOptions{Command: tt.command}, 300*time.Millisecond)
+			if result.Status != "CANCELED" {
+				t.Fatalf("status=%s，期望CANCELED", result.Status)
+			}
+			child, err := strconv.Atoi(strings.TrimSpace(result.Output))
+			if err != nil {
+				t.Fatalf("无法获取后台子进程的PID: %q", result.Output)
+			}
+			// 后台子进程结束后由init回收，稍等片刻
+			deadline := time.Now().Add(3 * time.Second)
+			for {
+				err := syscall.Kill(child, 0)
+				if errors.Is(err, syscall.ESRCH) {
+					return
+				}
+				if time.Now().After(deadline) {
+					syscall.Kill(child, syscall.SIGKILL)
+					t.Fatalf("后台子进程%d未被结束", child)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestKillGracePeriod(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		grace       time.Duration
+		termination string
+		output      string
+	}{
+		{"捕获TERM后正常退出", "trap 'echo flushed; exit 0' TERM; sleep 60 & wait", 5 * time.Second, "GRACEFUL", "flushed"},
+		{"忽略TERM时强制结束", "trap '' TERM; sleep 60 & wait", 300 * time.Millisecond, "KILLED", ""},
+		{"宽限期为0时直接强制结束", "trap 'echo flushed; exit 0' TERM; sleep 60 & wait", 0, "KILLED", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &killGrace, tt.grace)
+			start := time.Now()
+			result := runAndCancel(t, ExecOptions{Command: tt.command}, 300*time.Millisecond)
+			if result.Status != "CANCELED" || result.Termination != tt.termination {
+				t.Fatalf("status=%s termination=%s，期望CANCELED %s", result.Status, result.Termination, tt.termination)
+			}
+			if strings.TrimSpace(result.Output) != tt.output {
+				t.Errorf("output=%q，期望%q", result.Output, tt.output)
+			}
+			if tt.termination == "GRACEFUL" && time.Since(start) > tt.grace {
+				t.Errorf("正常退出时不应等待整个宽限期")
+			}
+		})
+	}
+}
+
+func TestTerminationSignal(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   func(pidFile string) ExecOptions
+		signal syscall.Signal
+		want   string
+	}{
+		{"直接执行被SIGKILL结束", func(pidFile string) ExecOptions {
+			return ExecOptions{Argv: []string{"sh", "-c", "echo $$ > " + pidFile + "; exec sleep 30"}}
+		}, syscall.SIGKILL, "SIGKILL"},
+		{"经shell执行的子进程被SIGTERM结束", func(pidFile string) ExecOptions {
+			return ExecOptions{Command: "sleep 30 & echo $! > " + pidFile + "; wait $!"}
+		}, syscall.SIGTERM, "SIGTERM"},
+		{"正常退出", func(pidFile string) ExecOptions {
+			return ExecOptions{Command: "echo $$ > " + pidFile + "; exit 1"}
+		}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			done := make(chan CommandResult, 1)
+			go func() { done <- runTestCommand(t, tt.opts(pidFile)) }()
+			if tt.signal != 0 {
+				syscall.Kill(waitPidFile(t, pidFile), tt.signal)
+			}
+			result := <-done
+			if result.Status != "FAILED" || result.Signal != tt.want {
+				t.Errorf("status=%s signal=%q，期望FAILED %q", result.Status, result.Signal, tt.want)
+			}
+		})
+	}
+}
+
+// 读取命令写入的PID
+func waitPidFile(t *testing.T, path string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, err := os.ReadFile(path)
+		if pid, convErr := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && convErr == nil {
+			return pid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("命令未写入PID: %s", path)
+	return 0
+}
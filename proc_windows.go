@@ -0,0 +1,152 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+	procGetConsoleOutputCP       = kernel32.NewProc("GetConsoleOutputCP")
+	procGetOEMCP                 = kernel32.NewProc("GetOEMCP")
+	procSetPriorityClass         = kernel32.NewProc("SetPriorityClass")
+
+	psapi                    = syscall.NewLazyDLL("psapi.dll")
+	procGetProcessMemoryInfo = psapi.NewProc("GetProcessMemoryInfo")
+)
+
+const (
+	processSetInformation    = 0x0200
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	normalPriorityClass      = 0x00000020
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+)
+
+// 将命令放入独立的进程组，便于停止时结束整个进程树
+func setupProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// 向进程组发送CTRL_BREAK，允许命令自行清理后退出
+func terminateProcessGroup(pid int) error {
+	r, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(pid))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// 通过taskkill强制结束整个进程树
+func killProcessGroup(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// 获取控制台输出代码页，无控制台（如作为服务运行）时使用OEM代码页
+func consoleCodePage() uint32 {
+	if cp, _, _ := procGetConsoleOutputCP.Call(); cp != 0 {
+		return uint32(cp)
+	}
+	cp, _, _ := procGetOEMCP.Call()
+	return uint32(cp)
+}
+
+// windows下不支持切换执行用户
+func initRunAs() error {
+	if runAs != "" {
+		return errors.New("--run-as不支持windows平台")
+	}
+	return nil
+}
+
+func applyRunAs(cmd *exec.Cmd) {}
+
+// windows下在进程启动后按nice值设置优先级类别
+func setupPriority() error {
+	if ioniceClass != 0 {
+		return errors.New("--ionice-class仅支持linux平台")
+	}
+	return nil
+}
+
+func afterStart(cmd *exec.Cmd) {
+	if niceLevel == 0 {
+		return
+	}
+	handle, err := syscall.OpenProcess(processSetInformation, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		logWarn("设置进程优先级失败 [PID:%d]: %v", cmd.Process.Pid, err)
+		return
+	}
+	defer syscall.CloseHandle(handle)
+	if r, _, err := procSetPriorityClass.Call(uintptr(handle), priorityClass(niceLevel)); r == 0 {
+		logWarn("设置进程优先级失败 [PID:%d]: %v", cmd.Process.Pid, err)
+	}
+}
+
+// 将nice值映射为windows的优先级类别
+func priorityClass(nice int) uintptr {
+	switch {
+	case nice <= -15:
+		return highPriorityClass
+	case nice < 0:
+		return aboveNormalPriorityClass
+	case nice == 0:
+		return normalPriorityClass
+	case nice < 10:
+		return belowNormalPriorityClass
+	default:
+		return idlePriorityClass
+	}
+}
+
+// windows下不支持资源限制
+func limitExceededStatus(state *os.ProcessState, exitCode int, output string) string {
+	return ""
+}
+
+// windows下进程不会被信号终止
+func terminationSignal(state *os.ProcessState) string {
+	return ""
+}
+
+func signalName(sig int) string {
+	return ""
+}
+
+// PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// 进程当前占用的物理内存（工作集，字节），获取失败时为0
+func processRSS() int64 {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	var counters processMemoryCounters
+	counters.cb = uint32(unsafe.Sizeof(counters))
+	ret, _, _ := procGetProcessMemoryInfo.Call(uintptr(process), uintptr(unsafe.Pointer(&counters)), uintptr(counters.cb))
+	if ret == 0 {
+		return 0
+	}
+	return int64(counters.WorkingSetSize)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	rateLimit float64 // --rate-limit，每个客户端IP每秒允许的请求数，0表示不限制
+	rateBurst int     // --rate-burst，允许的突发请求数，默认为rateLimit向上取整
+)
+
+// 记录的客户端数量上限，达到上限时移除令牌最多的客户端，直至剩余九成
+const maxRateClients = 10000
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateLock    sync.Mutex
+	rateBuckets = make(map[netip.Addr]*rateBucket)
+	ratePrune   time.Time
+)
+
+var rateLimitLog = &throttledLog{interval: 10 * time.Second}
+
+func initRateLimit() error {
+	if rateLimit < 0 || rateBurst < 0 {
+		return errors.New("--rate-limit、--rate-burst不能为负数")
+	}
+	if rateLimit == 0 {
+		return nil
+	}
+	if rateBurst == 0 {
+		rateBurst = int(math.Ceil(rateLimit))
+	}
+	logInfo("每个客户端IP每秒允许%g个请求，突发%d个", rateLimit, rateBurst)
+	return nil
+}
+
+// 令牌桶：按rateLimit匀速补充，最多rateBurst个，返回是否允许及需等待的时长
+func takeToken(addr netip.Addr, now time.Time) (bool, time.Duration) {
+	key := rateKey(addr)
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	b, ok := rateBuckets[key]
+	if !ok {
+		// 只在记录新的客户端时清理，已记录的客户端的请求不需要遍历
+		if now.Sub(ratePrune) > time.Minute || len(rateBuckets) >= maxRateClients {
+			pruneRateBuckets(now)
+		}
+		b = &rateBucket{tokens: float64(rateBurst), last: now}
+		rateBuckets[key] = b
+	}
+	b.tokens = b.refill(now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rateLimit * float64(time.Second))
+}
+
+// 补充到now时的令牌数
+func (b *rateBucket) refill(now time.Time) float64 {
+	return math.Min(float64(rateBurst), b.tokens+now.Sub(b.last).Seconds()*rateLimit)
+}
+
+// IPv6客户端按/64网段计数，避免同一网段轮换地址绕过限制
+func rateKey(addr netip.Addr) netip.Addr {
+	if !addr.Is6() || addr.Is4In6() {
+		return addr
+	}
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return addr
+	}
+	return prefix.Addr()
+}
+
+// 移除已补满的客户端，数量仍达到上限时按令牌数从多到少移除，直至剩余九成。
+// 受限的客户端令牌最少，最后才会被移除，不会因大量新地址的请求而重置；
+// 一次移除一成，避免每个新客户端的请求都遍历全部记录
+func pruneRateBuckets(now time.Time) {
+	type entry struct {
+		key    netip.Addr
+		tokens float64
+	}
+	var entries []entry
+	for key, b := range rateBuckets {
+		tokens := b.refill(now)
+		if tokens >= float64(rateBurst) {
+			delete(rateBuckets, key)
+			continue
+		}
+		entries = append(entries, entry{key, tokens})
+	}
+	ratePrune = now
+	if len(rateBuckets) < maxRateClients {
+		return
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return cmp.Compare(b.tokens, a.tokens) })
+	for _, e := range entries[:len(entries)-maxRateClients*9/10] {
+		delete(rateBuckets, e.key)
+	}
+}
+
+// 按客户端IP限制请求频率，超出时返回429及Retry-After，健康检查、版本号接口不受限制
+func rateLimitMiddleware(next http.Handler, exempt []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range exempt {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		addr := clientIP(r)
+		ok, wait := takeToken(addr, time.Now())
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rateLimitLog.warn("来自%s的请求过于频繁，已返回429", addr)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		sendError(w, "请求过于频繁，请稍后重试", http.StatusTooManyRequests)
+	})
+}
+
+// 限制输出频率的日志，间隔内的其余日志只计数
+type throttledLog struct {
+	interval time.Duration
+	mu       sync.Mutex
+	last     time.Time
+	skipped  int
+}
+
+func (l *throttledLog) warn(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.last) < l.interval {
+		l.skipped++
+		return
+	}
+	if l.skipped > 0 {
+		format += "（上次记录后另有" + strconv.Itoa(l.skipped) + "次）"
+	}
+	// 直接调用logMessage，日志中的文件及行号为warn的调用处
+	logMessage("WARN", format, args...)
+	l.last, l.skipped = time.Now(), 0
+}
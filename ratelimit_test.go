@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setRateLimit(t *testing.T, limit float64, burst int) {
+	t.Helper()
+	setGlobal(t, &rateLimit, limit)
+	setGlobal(t, &rateBurst, burst)
+	setGlobal(t, &rateBuckets, make(map[netip.Addr]*rateBucket))
+	setGlobal(t, &ratePrune, time.Time{})
+}
+
+func TestTakeToken(t *testing.T) {
+	setRateLimit(t, 2, 3)
+	addr := netip.MustParseAddr("192.0.2.1")
+	start := time.Now()
+	tests := []struct {
+		name  string
+		after time.Duration
+		ok    bool
+		wait  time.Duration
+	}{
+		{"突发1", 0, true, 0},
+		{"突发2", 0, true, 0},
+		{"突发3", 0, true, 0},
+		{"突发用尽", 0, false, 500 * time.Millisecond},
+		{"补充了半个", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"补充了一个", 500 * time.Millisecond, true, 0},
+		{"再次用尽", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"补满后不超过突发数1", 10 * time.Second, true, 0},
+		{"补满后不超过突发数2", 10 * time.Second, true, 0},
+		{"补满后不超过突发数3", 10 * time.Second, true, 0},
+		{"补满后不超过突发数4", 10 * time.Second, false, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		ok, wait := takeToken(addr, start.Add(tt.after))
+		if ok != tt.ok || wait != tt.wait {
+			t.Errorf("%s: takeToken() = %v, %s，期望%v, %s", tt.name, ok, wait, tt.ok, tt.wait)
+		}
+	}
+	// 各客户端IP分别计数
+	if ok, _ := takeToken(netip.MustParseAddr("2001:db8::1"), start); !ok {
+		t.Error("其他客户端IP不应受限")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	setRateLimit(t, 0.5, 1)
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), []string{"/healthz", "/version"})
+	tests := []struct {
+		name  string
+		path  string
+		code  int
+		retry string
+	}{
+		{"第一个请求", "/endpoint", http.StatusOK, ""},
+		{"超出限制", "/endpoint", http.StatusTooManyRequests, "2"},
+		{"健康检查不受限制", "/healthz", http.StatusOK, ""},
+		{"版本号不受限制", "/version", http.StatusOK, ""},
+		{"不受限制的请求不消耗令牌", "/endpoint", http.StatusTooManyRequests, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.code || w.Header().Get("Retry-After") != tt.retry {
+				t.Errorf("状态码%d，Retry-After=%q，期望%d、%q", w.Code, w.Header().Get("Retry-After"), tt.code, tt.retry)
+			}
+		})
+	}
+}
+
+func TestRateBucketsBounded(t *testing.T) {
+	setRateLimit(t, 1, 5)
+	now := time.Now()
+	first := netip.AddrFrom4([4]byte{10, 0, 0, 0})
+	addr := first
+	for i := 0; i < maxRateClients+100; i++ {
+		takeToken(addr, now.Add(time.Duration(i)*time.Microsecond))
+		addr = addr.Next()
+	}
+	if n := len(rateBuckets); n > maxRateClients {
+		t.Errorf("记录了%d个客户端，上限%d", n, maxRateClients)
+	}
+	if _, ok := rateBuckets[first]; ok {
+		t.Error("应移除令牌最多的客户端")
+	}
+	// 超过一分钟后移除已补满的客户端
+	takeToken(first, now.Add(2*time.Minute))
+	if n := len(rateBuckets); n != 1 {
+		t.Errorf("补满的客户端未移除，剩余%d个", n)
+	}
+}
+
+// 大量新地址的请求不会移除受限的客户端，使其恢复突发数
+func TestRateBucketsKeepThrottled(t *testing.T) {
+	setRateLimit(t, 1, 2)
+	now := time.Now()
+	victim := netip.MustParseAddr("192.0.2.1")
+	takeToken(victim, now)
+	takeToken(victim, now)
+	addr := netip.AddrFrom4([4]byte{10, 0, 0, 0})
+	for i := 0; i < maxRateClients*3; i++ {
+		takeToken(addr, now)
+		addr = addr.Next()
+	}
+	if n := len(rateBuckets); n > maxRateClients {
+		t.Errorf("记录了%d个客户端，上限%d", n, maxRateClients)
+	}
+	if ok, _ := takeToken(victim, now); ok {
+		t.Error("受限的客户端被移除后重置了令牌")
+	}
+}
+
+func TestRateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"IPv4分别计数", "192.0.2.1", "192.0.2.2", false},
+		{"同一/64网段的IPv6", "2001:db8:0:1::1", "2001:db8:0:1:ffff::2", true},
+		{"不同/64网段的IPv6", "2001:db8:0:1::1", "2001:db8:0:2::1", false},
+		{"IPv4映射的IPv6按IPv4计数", "::ffff:192.0.2.1", "::ffff:192.0.2.2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := rateKey(netip.MustParseAddr(tt.a)), rateKey(netip.MustParseAddr(tt.b))
+			if (a == b) != tt.same {
+				t.Errorf("rateKey(%s) = %s，rateKey(%s) = %s", tt.a, a, tt.b, b)
+			}
+		})
+	}
+
+	setRateLimit(t, 1, 1)
+	now := time.Now()
+	takeToken(netip.MustParseAddr("2001:db8::1"), now)
+	if ok, _ := takeToken(netip.MustParseAddr("2001:db8::2"), now); ok {
+		t.Error("同一/64网段的IPv6地址应共用令牌桶")
+	}
+}
+
+// 并发请求时每个客户端允许的请求数不超过突发数加上期间补充的令牌，记录的客户端数量不超过上限
+func TestTakeTokenConcurrent(t *testing.T) {
+	setRateLimit(t, 100, 10)
+	clients := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::1")}
+	allowed := make([]atomic.Int64, len(clients))
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 同时以大量新地址请求
+			addr := netip.AddrFrom4([4]byte{10, byte(i), 0, 0})
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				takeToken(addr, time.Now())
+				addr = addr.Next()
+			}
+		}(i)
+	}
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := i % len(clients)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if ok, _ := takeToken(clients[c], time.Now()); ok {
+					allowed[c].Add(1)
+				}
+			}
+		}(i)
+	}
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	limit := int64(float64(rateBurst) + elapsed.Seconds()*rateLimit + 1)
+	for i := range clients {
+		if n := allowed[i].Load(); n > limit || n == 0 {
+			t.Errorf("%s允许了%d个请求，上限%d", clients[i], n, limit)
+		}
+	}
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	if n := len(rateBuckets); n > maxRateClients {
+		t.Errorf("记录了%d个客户端，上限%d", n, maxRateClients)
+	}
+}
+
+// 替换标准输出，返回f执行期间输出的内容
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestThrottledLog(t *testing.T) {
+	l := &throttledLog{interval: time.Hour}
+	var line int
+	out := captureStdout(t, func() {
+		_, _, line, _ = runtime.Caller(0)
+		l.warn("第%d次", 1)
+		l.warn("第%d次", 2)
+		l.warn("第%d次", 3)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "第1次") {
+		t.Fatalf("间隔内只应输出一次: %q", out)
+	}
+	// 文件及行号为warn的调用处
+	if want := fmt.Sprintf("[ratelimit_test.go:%d]", line+1); !strings.Contains(lines[0], want) {
+		t.Errorf("日志中应包含%s: %s", want, lines[0])
+	}
+
+	l.last = time.Time{}
+	out = captureStdout(t, func() { l.warn("第%d次", 4) })
+	if !strings.Contains(out, "第4次（上次记录后另有2次）") {
+		t.Errorf("应记录间隔内略过的次数: %q", out)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	redactFlags     stringList // --redact，可重复指定
+	redactPatterns  []*regexp.Regexp
+	redactMultiline bool // 存在可能跨行匹配的规则，此时不支持按行写出的流式输出
+)
+
+const redactedText = "[REDACTED]"
+
+// 隐藏的密钥的最小长度，过短的值容易出现在正常输出中，替换后会破坏输出
+const minRedactSecret = 8
+
+// 编译--redact，正则无效时启动失败
+func initRedact() error {
+	for _, expr := range redactFlags {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("无效的--redact: %v", err)
+		}
+		redactPatterns = append(redactPatterns, re)
+		if canSpanLines(expr) {
+			redactMultiline = true
+			logWarn("--redact规则%s可能跨行匹配，将不支持stream=true、action=attach，--output-dir的输出文件在执行结束后写入", expr)
+		}
+	}
+	if len(redactPatterns) > 0 {
+		logInfo("命令输出中匹配%d条--redact规则的内容将替换为%s", len(redactPatterns), redactedText)
+	}
+	for _, t := range tokens {
+		warnShortSecret("token "+t.label, t.value)
+	}
+	warnShortSecret("签名密钥", signingSecret)
+	return nil
+}
+
+// 过短的密钥不会在命令输出中隐藏，提示改用更长的值
+func warnShortSecret(name, value string) {
+	if value != "" && len(value) < minRedactSecret {
+		logWarn("%s长度不足%d，不会在命令输出中隐藏，建议使用更长的值", name, minRedactSecret)
+	}
+}
+
+// 正则能否匹配换行符，能匹配时按行隐藏会漏掉跨行的内容
+func canSpanLines(expr string) bool {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return matchesNewline(re)
+}
+
+func matchesNewline(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpLiteral:
+		return slices.Contains(re.Rune, '\n')
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	return slices.ContainsFunc(re.Sub, matchesNewline)
+}
+
+// 存在跨行的规则时拒绝流式输出，避免按行写出时漏掉跨行的内容
+func checkStreamRedact() error {
+	if redactMultiline {
+		return errors.New("存在可能跨行匹配的--redact规则，不支持流式输出，请使用完整输出")
+	}
+	return nil
+}
+
+// 需要隐藏的密钥：配置的token（包括已过期、轮换后的）及签名密钥，不足minRedactSecret的不隐藏
+func redactSecrets() []string {
+	tokensLock.RLock()
+	defer tokensLock.RUnlock()
+	var secrets []string
+	for _, t := range slices.Concat(tokens, expiredTokens) {
+		if len(t.value) >= minRedactSecret {
+			secrets = append(secrets, t.value)
+		}
+	}
+	if len(signingSecret) >= minRedactSecret {
+		secrets = append(secrets, signingSecret)
+	}
+	return secrets
+}
+
+// 将输出中匹配--redact的内容及token替换为[REDACTED]
+func redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllLiteralString(s, redactedText)
+	}
+	for _, secret := range redactSecrets() {
+		s = strings.ReplaceAll(s, secret, redactedText)
+	}
+	return s
+}
+
+// 边执行边输出时按行隐藏后写入，不完整的行在flush时写入；
+// 存在跨行的规则时全部内容在flush时整段隐藏后写入
+type redactWriter struct {
+	w       io.Writer
+	mu      sync.Mutex
+	partial []byte
+	whole   bool
+}
+
+func newRedactWriter(w io.Writer) *redactWriter {
+	return &redactWriter{w: w, whole: redactMultiline}
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.partial = append(rw.partial, p...)
+	if rw.whole {
+		return len(p), nil
+	}
+	i := bytes.LastIndexByte(rw.partial, '\n')
+	// 超长的行不再等待换行，避免无限占用内存
+	if i < 0 && len(rw.partial) > 64<<10 {
+		i = len(rw.partial) - 1
+	}
+	if i < 0 {
+		return len(p), nil
+	}
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(string(rw.partial[:i+1]), "\n") {
+		out.WriteString(redact(line))
+	}
+	rw.partial = append([]byte(nil), rw.partial[i+1:]...)
+	// 写入失败由下层处理，不影响命令输出的采集
+	io.WriteString(rw.w, out.String())
+	return len(p), nil
+}
+
+func (rw *redactWriter) flush() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.partial) > 0 {
+		io.WriteString(rw.w, redact(string(rw.partial)))
+	}
+	rw.partial = nil
+}
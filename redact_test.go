@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	passwordPattern = `password=\S+`
+	keyPattern      = `(?s)-----BEGIN KEY-----.*?-----END KEY-----`
+	// 一行的password及跨行的密钥
+	secretOutput = "password=hunter2\n-----BEGIN KEY-----\nkey-body\n-----END KEY-----\ndone\n"
+)
+
+func setRedact(t *testing.T, patterns ...string) {
+	t.Helper()
+	compiled := make([]*regexp.Regexp, len(patterns))
+	multiline := false
+	for i, p := range patterns {
+		compiled[i] = regexp.MustCompile(p)
+		multiline = multiline || canSpanLines(p)
+	}
+	setGlobal(t, &redactPatterns, compiled)
+	setGlobal(t, &redactMultiline, multiline)
+	setTokens(t, authToken{label: "ci", value: "token-secret"})
+	setGlobal(t, &expiredTokens, []authToken{{label: "old", value: "old-secret"}})
+	setGlobal(t, &signingSecret, "signing-secret")
+}
+
+func TestRedact(t *testing.T) {
+	setRedact(t, passwordPattern, keyPattern)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"无匹配", "hello\n", "hello\n"},
+		{"单行", "login password=hunter2 ok\n", "login [REDACTED] ok\n"},
+		{"跨行", secretOutput, "[REDACTED]\n[REDACTED]\ndone\n"},
+		{"多处跨行", "-----BEGIN KEY-----\na\n-----END KEY-----\n-----BEGIN KEY-----\nb\n-----END KEY-----", "[REDACTED]\n[REDACTED]"},
+		{"token", "token=token-secret\n", "token=[REDACTED]\n"},
+		{"已过期的token", "old-secret\n", "[REDACTED]\n"},
+		{"签名密钥", "signing-secret\n", "[REDACTED]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.in); got != tt.want {
+				t.Errorf("redact(%q) = %q，期望%q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// 过短的token、签名密钥不隐藏，启动时提示
+func TestRedactShortSecret(t *testing.T) {
+	setRedact(t)
+	setTokens(t, authToken{label: "ci", value: "token-secret"}, authToken{label: "short", value: "ab12"})
+	setGlobal(t, &signingSecret, "key")
+	const in = "ab12 key token-secret\n"
+	if got, want := redact(in), "ab12 key [REDACTED]\n"; got != want {
+		t.Errorf("redact(%q) = %q，期望%q", in, got, want)
+	}
+	out := captureStdout(t, func() {
+		if err := initRedact(); err != nil {
+			t.Error(err)
+		}
+	})
+	for _, name := range []string{"token short", "签名密钥"} {
+		if !strings.Contains(out, name+"长度不足") {
+			t.Errorf("未提示%s过短: %q", name, out)
+		}
+	}
+	if strings.Contains(out, "token ci") {
+		t.Errorf("足够长的token不应提示: %q", out)
+	}
+}
+
+// 完整输出按整段匹配，stdout、stderr及合并的输出均能隐藏跨行的内容
+func TestRedactCommandOutput(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setRedact(t, passwordPattern, keyPattern)
+	// stdout、stderr由不同的协程读取，间隔写入以固定合并后的顺序
+	setCommand(t, "printf '"+strings.ReplaceAll(secretOutput, "\n", `\n`)+"'; sleep 0.1; printf 'token-secret\\n' >&2")
+	result := decodeResult(t, serveRequest(t, "", "{}"))
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"stdout", result.Stdout, "[REDACTED]\n[REDACTED]\ndone\n"},
+		{"stderr", result.Stderr, "[REDACTED]\n"},
+		{"output", result.Output, "[REDACTED]\n[REDACTED]\ndone\n[REDACTED]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %q，期望%q", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+// 停止执行时返回的partial_output同样按整段隐藏
+func TestRedactPartialOutput(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setRedact(t, passwordPattern, keyPattern)
+	setCommand(t, "printf '"+strings.ReplaceAll(secretOutput, "\n", `\n`)+"'; sleep 30")
+	started := decodeResult(t, serveRequest(t, "", `{"async":true}`))
+	waitCommandStarted(t, started.ExecID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		execLock.Lock()
+		n := len(executions[started.ExecID].Output.Bytes())
+		execLock.Unlock()
+		if n == len(secretOutput) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("命令未输出，已输出%d字节", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := decodeResult(t, serveRequest(t, "", `{"action":"stop","exec_id":"`+started.ExecID+`"}`))
+	const want = "[REDACTED]\n[REDACTED]\ndone\n"
+	if stopped.PartialOutput != want {
+		t.Errorf("partial_output = %q，期望%q", stopped.PartialOutput, want)
+	}
+	if last := waitExecutionDone(t, started.ExecID).Last; last == nil || last.Output != want {
+		t.Errorf("停止后的执行结果: %+v", last)
+	}
+}
+
+func TestCanSpanLines(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{passwordPattern, false},
+		{`token: .*`, false},
+		{`[a-z]+`, false},
+		{keyPattern, true},
+		{`BEGIN\s+KEY`, true},
+		{`secret="[^"]*"`, true},
+		{`a\nb`, true},
+	}
+	for _, tt := range tests {
+		if got := canSpanLines(tt.expr); got != tt.want {
+			t.Errorf("canSpanLines(%q) = %v，期望%v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// 流式输出按行隐藏，单行的内容即使分多次写入也能隐藏；存在跨行的规则时在flush时整段隐藏
+func TestRedactWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		writes   []string
+		want     string
+	}{
+		{"单行", []string{passwordPattern}, []string{"password=hunter2\n"}, "[REDACTED]\n"},
+		{"单行分多次写入", []string{passwordPattern}, []string{"pass", "word=hun", "ter2\nok\n"}, "[REDACTED]\nok\n"},
+		{"未换行的内容在flush时隐藏", []string{passwordPattern}, []string{"ok\npassword=hunter2"}, "ok\n[REDACTED]"},
+		{"token分多次写入", []string{passwordPattern}, []string{"token-", "secret\n"}, "[REDACTED]\n"},
+		{"跨行的规则", []string{passwordPattern, keyPattern}, []string{"password=hunter2\n-----BEGIN KEY-----\n", "key-body\n", "-----END KEY-----\ndone\n"}, "[REDACTED]\n[REDACTED]\ndone\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRedact(t, tt.patterns...)
+			var buf bytes.Buffer
+			rw := newRedactWriter(&buf)
+			for _, s := range tt.writes {
+				rw.Write([]byte(s))
+			}
+			rw.flush()
+			if buf.String() != tt.want {
+				t.Errorf("输出%q，期望%q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+// 存在跨行的规则时在执行结束前不写出任何内容
+func TestRedactWriterHoldsMultiline(t *testing.T) {
+	setRedact(t, keyPattern)
+	var buf bytes.Buffer
+	rw := newRedactWriter(&buf)
+	rw.Write([]byte("-----BEGIN KEY-----\nkey-body\n"))
+	if buf.Len() != 0 {
+		t.Errorf("flush前写出了%q", buf.String())
+	}
+}
+
+func TestRedactStreamedOutput(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "printf '"+strings.ReplaceAll(secretOutput, "\n", `\n`)+"'")
+	t.Run("按行匹配", func(t *testing.T) {
+		setRedact(t, passwordPattern)
+		w := serveRequest(t, "", `{"stream":true}`)
+		out, _, _ := strings.Cut(w.Body.String(), "{")
+		if strings.Contains(out, "hunter2") || !strings.Contains(out, "done") {
+			t.Errorf("流式输出: %q", out)
+		}
+	})
+	// 按行写出会漏掉跨行的内容，拒绝流式输出
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{"stream", "", `{"stream":true}`},
+		{"attach", "action=attach&exec_id=x", ""},
+	}
+	for _, tt := range tests {
+		t.Run("跨行的规则拒绝"+tt.name, func(t *testing.T) {
+			setRedact(t, passwordPattern, keyPattern)
+			w := serveRequest(t, tt.query, tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "跨行") {
+				t.Errorf("状态码%d，期望400: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// 存在跨行的规则时输出文件在执行结束后整段隐藏
+func TestRedactOutputFile(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setRedact(t, passwordPattern, keyPattern)
+	dir := t.TempDir()
+	setGlobal(t, &outputDir, dir)
+	setGlobal(t, &outputName, "{exec_id}.log")
+	setGlobal(t, &outputKeep, 0)
+	setCommand(t, "printf '"+strings.ReplaceAll(secretOutput, "\n", `\n`)+"'")
+	result := decodeResult(t, serveRequest(t, "", "{}"))
+	data, err := os.ReadFile(filepath.Join(dir, result.ExecID+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[REDACTED]\n[REDACTED]\ndone\n"; string(data) != want {
+		t.Errorf("输出文件%q，期望%q", data, want)
+	}
+}
@@ -5,20 +5,39 @@ import (
 	"context"
 	"crypto/rand"
 	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
 	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	mrand "math/rand/v2"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	rtdebug "runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -27,7 +46,9 @@ const (
 )
 
 type AppConfig struct {
-	Version string `yaml:"version"`
+	Version   string `yaml:"version"`
+	Commit    string `yaml:"commit"`
+	BuildDate string `yaml:"build_date"`
 }
 
 //go:embed config.yml
@@ -37,352 +58,4277 @@ var (
 	appConfig   AppConfig
 	port        string
 	command     string
-	token       string
 	endpoint    string
+	killGrace   time.Duration
 	showHelp    bool
 	showVersion bool
+
+	allowWorkdirs stringList
+	allowEnv      stringList
+	allowArgs     bool
+	paramDefs     stringList
+	noShell       bool
+	shell         string
+	windowsShell  string
+	outputCharset string
+	runAs         string
+	niceLevel     int
+	ioniceClass   int
+	limitMem      byteSize
+	limitCPU      int
+	maxOutput     byteSize = 1 << 20
+	maxBodyBytes  byteSize = 1 << 20
+	truncateMode  string
+	onFailure     string
+	onFailureAll  bool
+	dryRun        bool
+	checkMode     bool
+	checkTimeout  time.Duration
+	maxExecTime   time.Duration
+	cleanEnv      bool
+	keepEnv       stringList
+	pathOverride  string
+	pathPrepend   stringList
+	envFile       string
+	scriptPath    string
+	commandSteps  stringList
+	outputDir     string
+	outputName    string
+	outputKeep    int
+	instanceName  string
+	hostname      string
+	maxResults    = 100
+	historySize   = 100
+	dataDir       string
+	dataRetention time.Duration
+	debug         bool
+	restMode      bool
+	healthEnabled bool
+	healthPath    = "/healthz"
+	versionPath   string
+	allowShutdown bool
+	readyPath     = "/readyz"
+	readinessExec time.Duration
+
+	idempotencyTTL     time.Duration
+	idempotencyMaxKeys = 1000
+	idempotencyReject  bool
+	callbackAllow      stringList
+)
+
+// 可重复指定的命令行参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// 字节数参数，支持K、M、G后缀（按1024换算）
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的字节数: %s", v)
+	}
+	return n * unit, nil
+}
+
+// 展开逗号分隔的取值
+func (l stringList) values() []string {
+	var result []string
+	for _, v := range l {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+func (l stringList) contains(v string) bool {
+	for _, item := range l.values() {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+type Execution struct {
+	ID          string
+	Action      string // 执行方式：single、multiple、loop
+	Command     string
+	StartTime   time.Time
+	Iterations  int            // 已完成的执行次数
+	Failed      int            // 执行结果不是COMPLETED的次数
+	Total       int            // 多次执行的总次数，循环执行为max_iterations
+	Delay       time.Duration  // 多次、循环执行的间隔，循环执行可通过action=update调整
+	Last        *CommandResult // 最近一次执行的结果
+	Result      *CommandResult // 多次执行完成后的汇总结果
+	Cancel      context.CancelFunc
+	Done        chan struct{} // 执行协程完全结束后关闭
+	Stopped     bool
+	Pid         int  // 当前正在运行的命令进程ID，循环执行时每次迭代都会变化
+	Interrupted bool // 上次运行时服务退出导致未结束的循环执行
+	Paused      bool // 循环执行已暂停，当前这次执行结束后不再开始新的执行
+	// 暂停、调整等操作后唤醒循环执行协程
+	Wake chan struct{}
+	// action=trigger要求立即执行一次，结果通过传入的channel返回
+	Trigger chan chan CommandResult
+	// 循环执行连续失败达到FailThreshold次时自动停止，成功一次后重新计数
+	FailThreshold       int
+	ConsecutiveFailures int
+	// 循环执行的结束条件，同时设置时需全部满足
+	UntilMatch    *regexp.Regexp
+	UntilExitZero bool
+	ConditionMet  bool
+	Jitter        JitterParam // 每次间隔的随机抖动
+	// 连续失败时间隔按BackoffFactor倍增，不超过BackoffMax，成功后恢复为Delay
+	BackoffFactor float64
+	BackoffMax    time.Duration
+	NextDelay     time.Duration // 下一次等待的间隔，执行中及暂停时为0
+	// fixed_rate时按间隔定时开始执行，上一次执行未结束时按Overlap处理
+	ScheduleMode string
+	Overlap      string
+	Running      int // 正在进行的执行数，concurrent时可能有多个
+	Skipped      int // skip时因上一次执行未结束而跳过的次数
+	// 执行类别：running为请求触发的执行，scheduled为定时执行
+	Kind string
+	Tags []string
+	// 执行结束（或每次执行结束）时POST结果的地址
+	CallbackURL            string
+	CallbackEveryIteration bool
+	// action=stream的订阅方，每次执行结束时推送结果
+	Subscribers map[chan CommandResult]struct{}
+	// action=attach的订阅方，实时推送命令的输出行
+	LineSubscribers map[chan OutputLine]struct{}
+	// action=at的计划执行时间
+	ScheduledAt time.Time
+	// 当前迭代的实时输出，停止执行时用于返回已产生的部分输出
+	Output *limitedBuffer
+	// 当前这次执行的开始时间，两次执行的间隔中为空
+	IterationStartedAt time.Time
+	FinishedAt         time.Time
+	ExpiresAt          time.Time       // 循环执行max_duration到期的时间，暂停期间同样计时
+	Recent             []CommandResult // 循环执行最近几次的结果
+}
+
+var (
+	execLock   sync.Mutex
+	executions = make(map[string]*Execution)
+	// 最近结束的--max-results个执行，仍可通过action=status、action=result查询
+	finishedExecutions = make(map[string]*Execution)
+	finishedOrder      []string
+)
+
+// 循环执行保留结果的次数
+const recentResultsKeep = 20
+
+type CommandResult struct {
+	ExecID      string   `json:"exec_id"`
+	Status      string   `json:"status"`
+	Command     string   `json:"command"`
+	Message     string   `json:"message"`
+	ExecTime    string   `json:"exec_time"`
+	ExecSecond  float64  `json:"exec_second"`
+	ExecMs      int64    `json:"exec_ms"`
+	ExitCode    int      `json:"exit_code"`
+	Pid         int      `json:"pid,omitempty"`
+	Output      string   `json:"output"`
+	Stdout      string   `json:"stdout"`
+	Stderr      string   `json:"stderr"`
+	Hostname    string   `json:"hostname,omitempty"`
+	Instance    string   `json:"instance,omitempty"`    // --instance-name指定的实例名称
+	Tags        []string `json:"tags,omitempty"`        // 请求指定的执行标签
+	Termination string   `json:"termination,omitempty"` // 被停止时的退出方式：GRACEFUL、KILLED
+	Workdir     string   `json:"workdir,omitempty"`
+	Env         []string `json:"env,omitempty"` // 注入的环境变量名（不含值）
+	Args        []string `json:"args,omitempty"`
+	User        string   `json:"user,omitempty"`     // 执行命令的用户（--run-as）
+	Priority    string   `json:"priority,omitempty"` // 执行命令的优先级（--nice、--ionice-class）
+	// 输出超出--max-output-bytes时被截断，output_bytes_total为截断前的总字节数
+	OutputTruncated  bool         `json:"output_truncated,omitempty"`
+	OutputBytesTotal int64        `json:"output_bytes_total,omitempty"`
+	MatchedLines     *int         `json:"matched_lines,omitempty"`   // 按grep参数过滤后匹配的行数
+	Error            string       `json:"error,omitempty"`           // 命令未能启动（START_FAILED）的原因
+	PartialOutput    string       `json:"partial_output,omitempty"`  // 停止执行时命令已产生的输出
+	Attempts         int          `json:"attempts,omitempty"`        // 失败重试时的第几次尝试
+	Steps            []StepResult `json:"steps,omitempty"`           // 重复指定-c时各条命令的执行结果
+	OutputFile       string       `json:"output_file,omitempty"`     // --output-dir时输出写入的文件
+	Signal           string       `json:"signal,omitempty"`          // 进程被信号终止时的信号名，如SIGKILL
+	StartTimeUnix    int64        `json:"start_time_unix,omitempty"` // 开始时间的毫秒时间戳
+	EndTimeUnix      int64        `json:"end_time_unix,omitempty"`   // 结束时间的毫秒时间戳
+	ExecTimeISO      string       `json:"exec_time_iso,omitempty"`   // RFC3339格式的开始时间
+	// 命令失败后执行--on-failure指定命令的结果
+	OnFailure *CommandResult `json:"on_failure,omitempty"`
+	// 试运行时将要执行的命令详情
+	Invocation *Invocation `json:"invocation,omitempty"`
+	// collect时多次执行每次的结果及成功、失败次数
+	Results   []CommandResult `json:"results,omitempty"`
+	Succeeded *int            `json:"succeeded,omitempty"`
+	Failed    *int            `json:"failed,omitempty"`
+}
+
+// 记录执行的开始时间及耗时
+func (r *CommandResult) setTiming(start, end time.Time) {
+	elapsed := end.Sub(start)
+	r.ExecTime = start.Format(timeFormat)
+	r.ExecTimeISO = start.Format(time.RFC3339)
+	r.ExecSecond = elapsed.Seconds()
+	r.ExecMs = elapsed.Milliseconds()
+	r.StartTimeUnix = start.UnixMilli()
+	r.EndTimeUnix = end.UnixMilli()
+}
+
+// 多条命令中单条命令的执行结果
+type StepResult struct {
+	Command    string  `json:"command"`
+	Status     string  `json:"status"`
+	ExitCode   int     `json:"exit_code"`
+	ExecSecond float64 `json:"exec_second"`
+	ExecMs     int64   `json:"exec_ms"`
+	Output     string  `json:"output"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// 试运行时描述将要执行的命令
+type Invocation struct {
+	Argv       []string   `json:"argv,omitempty"`  // 最终执行的程序及参数（含shell包装）
+	Steps      [][]string `json:"steps,omitempty"` // 多条命令时每条命令的程序及参数
+	Workdir    string     `json:"workdir,omitempty"`
+	Env        []string   `json:"env,omitempty"` // 注入的环境变量名（不含值）
+	StdinBytes int        `json:"stdin_bytes,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Priority   string     `json:"priority,omitempty"`
+	OnFailure  string     `json:"on_failure,omitempty"`
+}
+
+// POST请求参数结构体
+type RequestParams struct {
+	Action      string            `json:"action"`
+	Delay       DurationParam     `json:"delay"`
+	Count       int               `json:"count"`
+	ExecID      string            `json:"exec_id"`
+	Workdir     string            `json:"workdir"`
+	Env         map[string]string `json:"env"`
+	Args        []string          `json:"args"`
+	Params      map[string]string `json:"params"`
+	Stdin       string            `json:"stdin"`
+	StdinBase64 string            `json:"stdin_base64"` // 二进制标准输入使用base64编码传递
+	Tail        int               `json:"tail"`
+	Grep        string            `json:"grep"`
+	Retries     int               `json:"retries"`
+	RetryDelay  DurationParam     `json:"retry_delay"`
+	DryRun      bool              `json:"dry_run"`
+	Timeout     DurationParam     `json:"timeout"`
+	Async       bool              `json:"async"`
+	Stream      bool              `json:"stream"` // 单次执行时边执行边返回输出
+	Limit       int               `json:"limit"`
+	Status      string            `json:"status"` // 按状态过滤执行历史
+	Since       string            `json:"since"`  // RFC3339格式，仅返回该时间之后的执行历史
+	Format      string            `json:"format"` // 导出执行历史的格式：csv、ndjson，执行时为text表示纯文本响应
+	Wait        bool              `json:"wait"`   // 停止时等待命令进程退出后再返回
+	Reset       bool              `json:"reset"`  // action=stats时重置统计
+	ExecIDs     []string          `json:"exec_ids"`
+	Tags        []string          `json:"tags"` // 执行的标签，用于分组查看及停止
+	Tag         string            `json:"tag"`  // action=list、stopAll按标签过滤
+	// 执行结束时POST结果的地址，callback_every_iteration时多次、循环执行每次结束都发送
+	CallbackURL            string `json:"callback_url"`
+	CallbackEveryIteration bool   `json:"callback_every_iteration"`
+	// 循环执行的结束条件，0表示不限制
+	MaxIterations int           `json:"max_iterations"`
+	MaxDuration   DurationParam `json:"max_duration"`
+	FailThreshold int           `json:"fail_threshold"` // 连续失败达到该次数时自动停止
+	UntilMatch    string        `json:"until_match"`    // 输出匹配该正则时结束
+	UntilExitZero bool          `json:"until_exit_zero"`
+	Jitter        JitterParam   `json:"jitter"` // 循环执行间隔的随机抖动
+	BackoffFactor float64       `json:"backoff_factor"`
+	BackoffMax    DurationParam `json:"backoff_max"`
+	Overlap       string        `json:"overlap"`       // 上一次执行未结束时的处理方式：wait、skip、concurrent
+	ScheduleMode  string        `json:"schedule_mode"` // fixed_delay、fixed_rate
+	RunAt         TimeParam     `json:"run_at"`        // action=at的执行时间
+	Collect       bool          `json:"collect"`       // 多次执行返回每次执行的结果
+	Parallel      int           `json:"parallel"`      // 多次执行同时执行的数量
+	FailFast      bool          `json:"fail_fast"`     // 多次执行第一次失败时中止剩余的执行
+	// stopAll的过滤条件
+	FilterAction    string `json:"filter_action"`
+	OlderThan       int    `json:"older_than"` // 运行时长超过该秒数
+	CommandContains string `json:"command_contains"`
+	Confirm         bool   `json:"confirm"` // action=shutdown时必须为true
+	// action=rotate-token的参数，只能通过POST传递
+	TokenLabel string        `json:"token_label"` // 轮换的token标签，默认为请求使用的token
+	NewToken   string        `json:"new_token"`   // 新token，为空时随机生成并在响应中返回
+	Grace      DurationParam `json:"grace"`       // 旧token继续有效的时长，默认立即失效
+	// action=mint-token的参数，只能通过POST传递
+	TTL          DurationParam `json:"ttl"`           // 一次性token的有效期，默认1小时
+	TokenActions []string      `json:"token_actions"` // 一次性token允许的action，为空时不限制
+}
+
+// 时长参数，可以是秒数或时长字符串（如90、"1h30m"）
+type DurationParam time.Duration
+
+func (d *DurationParam) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = DurationParam(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// 解析秒数或时长字符串，空字符串表示0
+func (d *DurationParam) Set(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = DurationParam(seconds * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("无效的时长: %s", s)
+	}
+	*d = DurationParam(v)
+	return nil
+}
+
+// 时间参数，可以是RFC3339格式或Unix时间戳（秒）
+type TimeParam struct {
+	time.Time
+}
+
+func (t *TimeParam) UnmarshalJSON(data []byte) error {
+	var seconds int64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		t.Time = time.Unix(seconds, 0)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return t.Set(s)
+}
+
+// 解析RFC3339格式或Unix时间戳，空字符串表示未设置
+func (t *TimeParam) Set(s string) error {
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		t.Time = time.Unix(seconds, 0)
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("无效的时间: %s", s)
+	}
+	t.Time = v
+	return nil
+}
+
+// 按秒显示整秒的间隔，其余按时长字符串显示，如5秒、500ms
+func formatDelay(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%d秒", d/time.Second)
+	}
+	return d.String()
+}
+
+// 间隔抖动参数，可以是秒数、时长字符串或间隔的百分比（如"20%"）
+type JitterParam struct {
+	Duration time.Duration
+	Percent  float64
+}
+
+func (j *JitterParam) UnmarshalJSON(data []byte) error {
+	var d DurationParam
+	if err := d.UnmarshalJSON(data); err == nil {
+		*j = JitterParam{Duration: time.Duration(d)}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return j.Set(s)
+}
+
+func (j *JitterParam) Set(s string) error {
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return fmt.Errorf("无效的百分比: %s", s)
+		}
+		*j = JitterParam{Percent: v}
+		return nil
+	}
+	var d DurationParam
+	if err := d.Set(s); err != nil {
+		return err
+	}
+	*j = JitterParam{Duration: time.Duration(d)}
+	return nil
+}
+
+func (j JitterParam) String() string {
+	if j.Percent != 0 {
+		return strconv.FormatFloat(j.Percent, 'f', -1, 64) + "%"
+	}
+	return j.Duration.String()
+}
+
+// 按间隔计算的抖动范围
+func (j JitterParam) amount(delay time.Duration) time.Duration {
+	if j.Percent != 0 {
+		return time.Duration(float64(delay) * j.Percent / 100)
+	}
+	return j.Duration
+}
+
+// 在[delay-jitter, delay+jitter]范围内随机取间隔，不小于0
+func applyJitter(delay time.Duration, jitter JitterParam) time.Duration {
+	j := jitter.amount(delay)
+	if j <= 0 {
+		return delay
+	}
+	d := delay + mrand.N(2*j+1) - j
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// 单次执行的选项，由请求参数校验后生成
+type ExecOptions struct {
+	Command  string // 渲染模板参数后的命令
+	Workdir  string
+	Env      []string // KEY=VALUE形式
+	EnvNames []string
+	Args     []string // 追加的命令参数，存在时不经过shell直接执行
+	Stdin    []byte   // 每次执行都会重新写入命令的标准输入
+	Argv     []string // 不经过shell直接执行时的完整参数列表
+	Tail     int      // 响应中仅返回输出的最后N行
+	Grep     *regexp.Regexp
+	Attempt  int // 失败重试时的第几次尝试，记录在结果中
+	Timeout  time.Duration
+	Steps    []ExecOptions // 重复指定-c时按顺序执行的各条命令
+	Output   io.Writer     // 同时写入命令的输出，用于流式响应
+}
+
+func init() {
+	flag.StringVar(&port, "p", "", "监听的端口号")
+	flag.Var(&commandSteps, "c", "要执行的命令（重复指定时按顺序执行，遇到失败即停止）")
+	flag.StringVar(&scriptPath, "script", "", "要执行的脚本文件，与-c二选一")
+	flag.Var(&tokenFlags, "token", "认证token，格式为value、label:value或label:value:过期时间（可重复或以逗号分隔）")
+	flag.StringVar(&tokenEnv, "token-env", "", "从该环境变量读取认证token")
+	flag.StringVar(&tokenFile, "token-file", "", "从该文件读取认证token，每行一个")
+	flag.Var(&roleFlags, "role", "限制token可执行的action，格式为label=action1,action2（可重复）")
+	flag.BoolVar(&readOnly, "read-only", false, "只读模式，只允许查看，不能执行或停止命令")
+	flag.Var(&redactFlags, "redact", "命令输出中需隐藏的内容的正则（可重复）")
+	flag.Var(&allowIPFlags, "allow-ip", "允许访问的IP或CIDR（可重复或以逗号分隔）")
+	flag.Var(&trustedProxyFlags, "trusted-proxy", "信任的代理IP或CIDR，来自代理的请求按X-Forwarded-For、X-Real-IP识别客户端（可重复或以逗号分隔）")
+	flag.Float64Var(&rateLimit, "rate-limit", 0, "每个客户端IP每秒允许的请求数")
+	flag.IntVar(&rateBurst, "rate-burst", 0, "允许的突发请求数")
+	flag.StringVar(&auditLogPath, "audit-log", "", "审计日志文件，以JSON Lines记录每个接口请求")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS证书文件，与--tls-key同时指定时以HTTPS提供服务")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS私钥文件")
+	flag.BoolVar(&tlsSelfSigned, "tls-self-signed", false, "启动时生成自签名证书并以HTTPS提供服务")
+	flag.Var(&acmeDomains, "acme-domain", "通过ACME（Let's Encrypt）自动申请证书的域名（可重复或以逗号分隔）")
+	flag.StringVar(&acmeCacheDir, "acme-cache-dir", "", "ACME账号密钥及证书的保存目录，默认为--data-dir下的acme目录")
+	flag.StringVar(&signingSecret, "signing-secret", "", "请求签名的密钥，设置后请求须携带HMAC-SHA256签名")
+	flag.Var(&allowActions, "allow-actions", "服务端启用的action（逗号分隔，可重复），默认全部启用")
+	flag.StringVar(&endpoint, "endpoint", "", "自定义端点路径")
+	flag.DurationVar(&killGrace, "kill-grace", 10*time.Second, "停止执行时等待进程退出的宽限期")
+	flag.Var(&allowWorkdirs, "allow-workdir", "允许请求指定的工作目录（可重复）")
+	flag.Var(&allowEnv, "allow-env", "允许请求注入的环境变量名（逗号分隔，可重复）")
+	flag.BoolVar(&allowArgs, "allow-args", false, "允许请求追加命令参数")
+	flag.Var(&paramDefs, "param", "命令模板参数及其校验正则，格式 name=regex（可重复）")
+	flag.BoolVar(&noShell, "no-shell", false, "不经过shell直接执行命令")
+	flag.StringVar(&shell, "shell", "", "执行命令使用的shell，如 \"bash -c\"")
+	flag.StringVar(&windowsShell, "windows-shell", "", "windows下执行命令使用的shell（cmd、powershell、pwsh）")
+	flag.StringVar(&outputCharset, "output-encoding", "", "命令输出的编码，如gbk，默认windows下按控制台代码页自动识别")
+	flag.StringVar(&runAs, "run-as", "", "以指定用户执行命令，格式 user[:group]")
+	flag.IntVar(&niceLevel, "nice", 0, "执行命令的nice值（-20~19）")
+	flag.IntVar(&ioniceClass, "ionice-class", 0, "执行命令的IO调度类别（1实时、2尽力而为、3空闲），仅linux")
+	flag.Var(&limitMem, "limit-mem", "执行命令的虚拟内存上限，如512M，仅linux")
+	flag.IntVar(&limitCPU, "limit-cpu-seconds", 0, "执行命令的CPU时间上限（秒），仅linux")
+	flag.Var(&maxOutput, "max-output-bytes", "单次执行保留的最大输出字节数，0表示不限制")
+	flag.Var(&maxBodyBytes, "max-body-bytes", "请求内容的最大字节数，0表示不限制")
+	flag.StringVar(&truncateMode, "truncate-mode", "tail", "输出超出上限时的截断方式（tail保留尾部、head保留头部）")
+	flag.StringVar(&onFailure, "on-failure", "", "命令执行失败后执行的处理命令")
+	flag.BoolVar(&onFailureAll, "on-failure-always", false, "命令被停止或超时也执行--on-failure命令")
+	flag.BoolVar(&dryRun, "dry-run", false, "所有请求均为试运行，只返回将要执行的命令而不实际执行")
+	flag.BoolVar(&checkMode, "check", false, "执行一次命令并输出结果后退出，不启动服务")
+	flag.DurationVar(&checkTimeout, "check-timeout", 30*time.Second, "--check执行命令的超时时间")
+	flag.DurationVar(&maxExecTime, "max-exec-time", 0, "单次命令执行的最长时间，请求的timeout不能超过该值")
+	flag.BoolVar(&cleanEnv, "clean-env", false, "命令不继承服务端的环境变量，仅保留最小集合")
+	flag.Var(&keepEnv, "keep-env", "--clean-env时额外保留的环境变量名（逗号分隔，可重复）")
+	flag.StringVar(&pathOverride, "path", "", "替换命令可见的PATH")
+	flag.Var(&pathPrepend, "path-prepend", "添加到命令可见PATH之前的目录（可重复）")
+	flag.StringVar(&envFile, "env-file", "", "每次执行时读取的环境变量文件（KEY=VALUE格式）")
+	flag.StringVar(&outputDir, "output-dir", "", "将每次执行的输出写入该目录下的文件")
+	flag.StringVar(&outputName, "output-name", "{exec_id}_{timestamp}.log", "输出文件名模板，支持{exec_id}、{timestamp}")
+	flag.IntVar(&outputKeep, "output-keep", 0, "输出目录中最多保留的文件数，0表示不清理")
+	flag.StringVar(&instanceName, "instance-name", "", "实例名称，包含在每个执行结果中")
+	flag.IntVar(&maxResults, "max-results", maxResults, "保留结果的已结束执行数")
+	flag.IntVar(&historySize, "history-size", historySize, "执行历史保留的条数")
+	flag.StringVar(&dataDir, "data-dir", "", "持久化执行历史的数据目录")
+	flag.DurationVar(&dataRetention, "data-retention", 30*24*time.Hour, "数据目录中执行历史的保留时间")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", 24*time.Hour, "按Idempotency-Key缓存单次执行结果的时间")
+	flag.IntVar(&idempotencyMaxKeys, "idempotency-keys", idempotencyMaxKeys, "最多缓存的Idempotency-Key数量")
+	flag.BoolVar(&idempotencyReject, "idempotency-reject", false, "相同Idempotency-Key的请求正在执行时返回409而不是等待")
+	flag.Var(&callbackAllow, "callback-allow", "允许的回调主机（可重复）")
+	flag.BoolVar(&debug, "debug", false, "输出调试日志")
+	flag.BoolVar(&restMode, "rest", false, "在端点路径下注册RESTful路由")
+	flag.BoolVar(&healthEnabled, "health", false, "提供不需要token的健康检查接口")
+	flag.StringVar(&healthPath, "health-path", healthPath, "健康检查接口的路径")
+	flag.StringVar(&readyPath, "ready-path", readyPath, "就绪检查接口的路径")
+	flag.DurationVar(&readinessExec, "readiness-exec", 0, "就绪检查时试运行命令的最小间隔，0表示不试运行")
+	flag.StringVar(&versionPath, "version-path", "", "提供不需要token的版本号接口的路径")
+	flag.BoolVar(&allowShutdown, "allow-shutdown", false, "允许通过action=shutdown远程关闭服务")
+	flag.BoolVar(&showVersion, "v", false, "显示版本号")
+	flag.BoolVar(&showHelp, "help", false, "显示帮助信息")
+}
+
+func main() {
+	initAppConfig()
+	flag.Parse()
+	setupLogger()
+
+	if showVersion {
+		fmt.Println(appConfig.Version)
+		if appConfig.Commit != "" {
+			fmt.Println("commit:", appConfig.Commit)
+		}
+		if appConfig.BuildDate != "" {
+			fmt.Println("build_date:", appConfig.BuildDate)
+		}
+		return
+	}
+
+	if showHelp || len(os.Args) == 1 {
+		printHelp()
+		return
+	}
+
+	// 多条命令按顺序执行，command仅用于展示
+	command = strings.Join(commandSteps, " && ")
+	if (port == "" && !checkMode) || (command == "" && scriptPath == "") {
+		logError("必须提供端口号(-p)和命令(-c或--script)")
+		os.Exit(1)
+	}
+
+	setups := []func() error{
+		initScript, initAllowWorkdirs, initCommandTemplate, initNoShell, initShell, initOutputEncoding,
+		initRunAs, initPriority, initLimits, initTruncateMode, initOnFailure,
+		initCleanEnv, initPath, initEnvFile, initOutputDir, initIdentity,
+		initDataDir, initCallback, initTokens, initRoles, initAllowActions, initTLS, initACME, initAllowIPs, initRateLimit, initAuditLog, initRedact,
+	}
+	for _, setup := range setups {
+		if err := setup(); err != nil {
+			logError("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	if checkMode {
+		os.Exit(runCheck())
+	}
+
+	startServer()
+}
+
+// 启动自检：按实际执行配置运行一次命令，输出结果，成功返回0
+func runCheck() int {
+	opts, err := buildExecOptions(RequestParams{})
+	if err != nil {
+		logError("自检失败: %v", err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if opts.Timeout == 0 || opts.Timeout > checkTimeout {
+		opts.Timeout = checkTimeout
+	}
+
+	result := executeCommand(ctx, generateID(), opts)
+	result.Hostname, result.Instance = hostname, instanceName
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		logError("结果编码失败: %v", err)
+	}
+	if result.Status != "COMPLETED" {
+		return 1
+	}
+	return 0
+}
+
+func initAppConfig() {
+	if len(embeddedConfig) == 0 {
+		appConfig.Version = "unknown" // 默认版本号
+	} else if err := yaml.Unmarshal(embeddedConfig, &appConfig); err != nil {
+		logWarn("解析配置文件失败: %v", err)
+		appConfig.Version = "unknown" // 解析失败时设置默认版本号
+	}
+
+	// 配置文件未指定提交及构建时间时，使用go build记录的版本控制信息
+	info, ok := rtdebug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && appConfig.Commit == "":
+			appConfig.Commit = setting.Value
+		case setting.Key == "vcs.time" && appConfig.BuildDate == "":
+			appConfig.BuildDate = setting.Value
+		}
+	}
+}
+
+func startServer() {
+	endpointPath := getEndpoint()
+	origin := fmt.Sprintf("%s://%s:%s", serverScheme(), serverHost(), port)
+	url := origin + "/" + endpointPath
+
+	http.HandleFunc("/"+endpointPath, authMiddleware(requestHandler))
+	http.HandleFunc("/"+endpointPath+"/openapi.json", authMiddleware(openAPIHandler(endpointPath)))
+	if restMode {
+		registerRestRoutes(endpointPath)
+	}
+	probes := registerHealthRoutes()
+	exempt := probes
+	if versionPath != "" {
+		path := "/" + strings.TrimPrefix(versionPath, "/")
+		http.HandleFunc(path, handleVersion)
+		exempt = append(exempt, path)
+		logInfo("版本号地址：%s%s", origin, path)
+	}
+	identity := "主机名：" + hostname
+	if instanceName != "" {
+		identity += "，实例名称：" + instanceName
+	}
+	logInfo("服务启动成功，监听地址：%s，%s", url, identity)
+	if readOnly {
+		logInfo("只读模式，允许的action：%s", strings.Join(enabledActions(), ","))
+	}
+	if restMode {
+		logInfo("RESTful接口地址：%s/v1/executions", url)
+	}
+	for _, path := range probes {
+		logInfo("健康检查地址：%s%s", origin, path)
+	}
+	if authEnabled() {
+		logInfo("token已设置：%s，接口调用时需传递请求头：'token: <token>'", tokenSummary())
+	}
+	if signingSecret != "" {
+		logInfo("已启用请求签名，接口调用时需传递请求头：X-Remotec-Timestamp、X-Remotec-Nonce、X-Remotec-Signature")
+	}
+
+	var handler http.Handler = http.DefaultServeMux
+	if maxBodyBytes > 0 {
+		handler = limitBodyMiddleware(handler)
+	}
+	if rateLimit > 0 {
+		handler = rateLimitMiddleware(handler, exempt)
+	}
+	if len(allowIPs) > 0 {
+		handler = allowIPMiddleware(handler)
+	}
+	server = &http.Server{Addr: ":" + port, Handler: handler}
+	if err := listenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logError("服务器启动失败: %v", err)
+		if acmeManager != nil {
+			logError("ACME证书须通过443端口访问，请确认-p %s可被公网访问且未被占用", port)
+		}
+		os.Exit(1)
+	}
+	<-shutdownDone
+	closeAuditLog()
+	logInfo("服务已关闭")
+}
+
+// 限制请求内容的大小，超出--max-body-bytes时读取返回*http.MaxBytesError
+func limitBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBodyBytes))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+func requestHandler(w http.ResponseWriter, r *http.Request) {
+	// 支持GET和POST方法
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		sendError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	params, ok := parseRequestParams(w, r)
+	if !ok {
+		return
+	}
+	dispatchRequest(w, r, params)
+}
+
+// 解析请求参数，POST从JSON body解析，其他方法从查询参数解析，参数无效时返回400
+func parseRequestParams(w http.ResponseWriter, r *http.Request) (RequestParams, bool) {
+	var params RequestParams
+	var err error
+
+	if r.Method != http.MethodPost {
+		// 从查询参数解析
+		params.Action = r.URL.Query().Get("action")
+		// 时长参数可以是秒数或时长字符串，无效时返回400
+		for name, d := range map[string]*DurationParam{
+			"delay":       &params.Delay,
+			"retry_delay": &params.RetryDelay,
+			"timeout":     &params.Timeout,
+		} {
+			if err := d.Set(r.URL.Query().Get(name)); err != nil {
+				sendError(w, name+err.Error(), http.StatusBadRequest)
+				return params, false
+			}
+		}
+		params.Count, _ = strconv.Atoi(r.URL.Query().Get("count"))
+		params.MaxIterations, _ = strconv.Atoi(r.URL.Query().Get("max_iterations"))
+		params.FailThreshold, _ = strconv.Atoi(r.URL.Query().Get("fail_threshold"))
+		params.UntilMatch = r.URL.Query().Get("until_match")
+		params.UntilExitZero, _ = strconv.ParseBool(r.URL.Query().Get("until_exit_zero"))
+		if err := params.Jitter.Set(r.URL.Query().Get("jitter")); err != nil {
+			sendError(w, "jitter"+err.Error(), http.StatusBadRequest)
+			return params, false
+		}
+		params.BackoffFactor, _ = strconv.ParseFloat(r.URL.Query().Get("backoff_factor"), 64)
+		params.Overlap = r.URL.Query().Get("overlap")
+		params.ScheduleMode = r.URL.Query().Get("schedule_mode")
+		params.Collect, _ = strconv.ParseBool(r.URL.Query().Get("collect"))
+		params.Parallel, _ = strconv.Atoi(r.URL.Query().Get("parallel"))
+		params.FailFast, _ = strconv.ParseBool(r.URL.Query().Get("fail_fast"))
+		if err := params.RunAt.Set(r.URL.Query().Get("run_at")); err != nil {
+			sendError(w, "run_at"+err.Error(), http.StatusBadRequest)
+			return params, false
+		}
+		if err := params.BackoffMax.Set(r.URL.Query().Get("backoff_max")); err != nil {
+			sendError(w, "backoff_max"+err.Error(), http.StatusBadRequest)
+			return params, false
+		}
+		if err := params.MaxDuration.Set(r.URL.Query().Get("max_duration")); err != nil {
+			sendError(w, "max_duration"+err.Error(), http.StatusBadRequest)
+			return params, false
+		}
+		params.ExecID = r.URL.Query().Get("exec_id")
+		// 批量停止时exec_ids可重复传递或以逗号分隔
+		for _, ids := range r.URL.Query()["exec_ids"] {
+			params.ExecIDs = append(params.ExecIDs, strings.Split(ids, ",")...)
+		}
+		// 标签可重复传递或以逗号分隔
+		for _, tags := range r.URL.Query()["tags"] {
+			params.Tags = append(params.Tags, strings.Split(tags, ",")...)
+		}
+		params.Tag = r.URL.Query().Get("tag")
+		params.CallbackURL = r.URL.Query().Get("callback_url")
+		params.CallbackEveryIteration, _ = strconv.ParseBool(r.URL.Query().Get("callback_every_iteration"))
+		params.Workdir = r.URL.Query().Get("workdir")
+		// 环境变量形如 env=KEY=VALUE，可重复传递
+		for _, kv := range r.URL.Query()["env"] {
+			if params.Env == nil {
+				params.Env = make(map[string]string)
+			}
+			name, value, _ := strings.Cut(kv, "=")
+			params.Env[name] = value
+		}
+		params.Args = r.URL.Query()["args"]
+		params.Stdin = r.URL.Query().Get("stdin")
+		params.StdinBase64 = r.URL.Query().Get("stdin_base64")
+		params.Tail, _ = strconv.Atoi(r.URL.Query().Get("tail"))
+		params.Grep = r.URL.Query().Get("grep")
+		params.Retries, _ = strconv.Atoi(r.URL.Query().Get("retries"))
+		params.DryRun, _ = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		params.Async, _ = strconv.ParseBool(r.URL.Query().Get("async"))
+		params.Stream, _ = strconv.ParseBool(r.URL.Query().Get("stream"))
+		params.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+		params.Status = r.URL.Query().Get("status")
+		params.Since = r.URL.Query().Get("since")
+		params.Format = r.URL.Query().Get("format")
+		params.Wait, _ = strconv.ParseBool(r.URL.Query().Get("wait"))
+		params.Reset, _ = strconv.ParseBool(r.URL.Query().Get("reset"))
+		params.Confirm, _ = strconv.ParseBool(r.URL.Query().Get("confirm"))
+		params.FilterAction = r.URL.Query().Get("filter_action")
+		params.OlderThan, _ = strconv.Atoi(r.URL.Query().Get("older_than"))
+		params.CommandContains = r.URL.Query().Get("command_contains")
+		// 模板参数形如 params=name=value，可重复传递
+		for _, kv := range r.URL.Query()["params"] {
+			if params.Params == nil {
+				params.Params = make(map[string]string)
+			}
+			name, value, _ := strings.Cut(kv, "=")
+			params.Params[name] = value
+		}
+	} else {
+		// 从JSON body解析
+		defer r.Body.Close()
+		if err = json.NewDecoder(r.Body).Decode(&params); err != nil {
+			if isBodyTooLarge(err) {
+				sendError(w, fmt.Sprintf("请求内容超过%d字节", maxBodyBytes), http.StatusRequestEntityTooLarge)
+				return params, false
+			}
+			sendError(w, "无效的JSON格式", http.StatusBadRequest)
+			return params, false
+		}
+		// 处理可能缺失的字段（默认值处理）
+		if params.Action == "" {
+			params.Action = "single" // 默认行为，类似原逻辑
+		}
+	}
+	return params, true
+}
+
+// 执行命令的action，未指定action时为单次执行
+var execActions = []string{"", "single", "multiple", "loop", "at"}
+
+// 按action分发请求
+func dispatchRequest(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	auditParams(r, params)
+	if err := authorizeAction(r, params.Action); err != nil {
+		sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	// 执行类action在参数校验通过后才消耗一次性token
+	if !slices.Contains(execActions, params.Action) && !spendRequestToken(w, r) {
+		return
+	}
+
+	switch params.Action {
+	case "stop":
+		handleStop(w, r, params)
+		return
+	case "stopAll":
+		handleStopAll(w, r, params)
+		return
+	case "list":
+		handleList(w, r, params)
+		return
+	case "schedules":
+		handleSchedules(w, r)
+		return
+	case "stream":
+		handleStream(w, r, params)
+		return
+	case "attach":
+		handleAttach(w, r, params)
+		return
+	case "wait":
+		handleWait(w, r, params)
+		return
+	case "info":
+		handleInfo(w, r)
+		return
+	case "stats":
+		handleStats(w, r, params)
+		return
+	case "validate":
+		handleValidate(w, r, params)
+		return
+	case "shutdown":
+		handleShutdown(w, r, params)
+		return
+	case "rotate-token":
+		handleRotateToken(w, r, params)
+		return
+	case "mint-token":
+		handleMintToken(w, r, params)
+		return
+	case "status":
+		handleStatus(w, r, params)
+		return
+	case "result":
+		handleResult(w, r, params)
+		return
+	case "history":
+		handleHistory(w, r, params)
+		return
+	case "export":
+		handleExport(w, r, params)
+		return
+	case "pause":
+		handlePause(w, r, params, true)
+		return
+	case "resume":
+		handlePause(w, r, params, false)
+		return
+	case "update":
+		handleUpdate(w, r, params)
+		return
+	case "trigger":
+		handleTrigger(w, r, params)
+		return
+	}
+
+	// 单次、多次执行可只返回命令输出
+	if params.Action != "loop" && params.Action != "at" && wantsText(r, params) {
+		w = &textResponseWriter{ResponseWriter: w}
+	}
+
+	// 执行请求可指定exec_id，已在执行中时不重复执行
+	if params.ExecID != "" && !execIDPattern.MatchString(params.ExecID) {
+		sendError(w, "无效的exec_id，只能包含字母、数字、_、-，长度1~64", http.StatusBadRequest)
+		return
+	}
+	if err := validateTags(params.Tags); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCallbackURL(params.CallbackURL); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.Stream && (params.Action == "" || params.Action == "single") {
+		if err := checkStreamRedact(); err != nil {
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts, err := buildExecOptions(params)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dryRun || params.DryRun {
+		handleDryRun(w, r, params, opts)
+		return
+	}
+	if !spendRequestToken(w, r) {
+		return
+	}
+
+	switch params.Action {
+	case "multiple":
+		handleMultiple(w, r, params, opts)
+	case "loop":
+		handleLoop(w, r, params, opts)
+	case "at":
+		handleAt(w, r, params, opts)
+	default:
+		if params.Stream {
+			handleSingleStream(w, r, params, opts)
+			return
+		}
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			handleIdempotent(w, r, key, func(w http.ResponseWriter) { handleSingle(w, r, params, opts) })
+			return
+		}
+		handleSingle(w, r, params, opts)
+	}
+}
+
+// 校验请求参数并生成执行选项
+func buildExecOptions(params RequestParams) (ExecOptions, error) {
+	var opts ExecOptions
+	rendered, err := renderCommand(command, params.Params)
+	if err != nil {
+		return opts, err
+	}
+	opts.Command = rendered
+
+	workdir, err := resolveWorkdir(params.Workdir)
+	if err != nil {
+		return opts, err
+	}
+	opts.Workdir = workdir
+
+	names := make([]string, 0, len(params.Env))
+	for name := range params.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return opts, fmt.Errorf("无效的环境变量名: %q", name)
+		}
+		if !allowEnv.contains(name) {
+			return opts, fmt.Errorf("不允许注入环境变量: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts.Env = append(opts.Env, name+"="+params.Env[name])
+	}
+	opts.EnvNames = names
+
+	if len(params.Args) > 0 {
+		if !allowArgs {
+			return opts, errors.New("服务端未允许追加命令参数")
+		}
+		opts.Args = params.Args
+	}
+	if len(opts.Args) > 0 && len(commandSteps) > 1 {
+		return opts, errors.New("多条命令时不支持追加命令参数")
+	}
+	if scriptPath != "" {
+		opts.Argv = append(append(scriptInterpreter(), scriptPath), opts.Args...)
+	} else if noShell || len(opts.Args) > 0 {
+		argv, err := splitCommandLine(opts.Command)
+		if err != nil {
+			return opts, err
+		}
+		opts.Argv = append(argv, opts.Args...)
+	}
+
+	opts.Tail = params.Tail
+
+	// 请求的超时时间只能缩短服务端的上限
+	opts.Timeout = maxExecTime
+	if params.Timeout > 0 {
+		timeout := time.Duration(params.Timeout)
+		if maxExecTime > 0 && timeout > maxExecTime {
+			return opts, fmt.Errorf("timeout不能超过服务端上限%s", maxExecTime)
+		}
+		opts.Timeout = timeout
+	}
+	if params.Grep != "" {
+		re, err := regexp.Compile(params.Grep)
+		if err != nil {
+			return opts, fmt.Errorf("无效的grep正则: %v", err)
+		}
+		opts.Grep = re
+	}
+
+	switch {
+	case params.Stdin != "" && params.StdinBase64 != "":
+		return opts, errors.New("stdin和stdin_base64不能同时指定")
+	case params.StdinBase64 != "":
+		data, err := base64.StdEncoding.DecodeString(params.StdinBase64)
+		if err != nil {
+			return opts, errors.New("无效的stdin_base64编码")
+		}
+		opts.Stdin = data
+	case params.Stdin != "":
+		opts.Stdin = []byte(params.Stdin)
+	}
+
+	if len(commandSteps) > 1 {
+		for _, tmpl := range commandSteps {
+			step := opts
+			step.Command, _ = renderCommand(tmpl, params.Params)
+			step.Argv = nil
+			if noShell {
+				if step.Argv, err = splitCommandLine(step.Command); err != nil {
+					return opts, err
+				}
+			}
+			// 超时时间作用于全部命令，由runSteps统一控制
+			step.Timeout = 0
+			opts.Steps = append(opts.Steps, step)
+		}
+	}
+	return opts, nil
+}
+
+// 校验--script指定的脚本文件，脚本每次执行时由shell重新读取
+func initScript() error {
+	if scriptPath == "" {
+		return nil
+	}
+	if command != "" {
+		return errors.New("-c与--script不能同时使用")
+	}
+	if noShell || len(paramDefs) > 0 {
+		return errors.New("--script不能与--no-shell、--param同时使用")
+	}
+	path, err := filepath.Abs(scriptPath)
+	if err != nil {
+		return fmt.Errorf("无效的脚本路径: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("脚本文件不存在: %s", path)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("脚本不是普通文件: %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("脚本文件不可读: %v", err)
+	}
+	f.Close()
+	scriptPath = path
+	// 结果中的command显示脚本路径
+	command = path
+	return nil
+}
+
+// 执行脚本文件的解释器及参数
+func scriptInterpreter() []string {
+	if runtime.GOOS == "windows" {
+		ps := windowsShell
+		if ps != "powershell" && ps != "pwsh" && strings.EqualFold(filepath.Ext(scriptPath), ".ps1") {
+			ps = "powershell"
+		}
+		if ps == "powershell" || ps == "pwsh" {
+			return []string{ps, "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}
+		}
+	}
+	// sh -c script.sh会把路径当作命令执行，去掉-c后由shell读取脚本
+	interp := shellArgv
+	if n := len(interp); n > 1 && interp[n-1] == "-c" {
+		interp = interp[:n-1]
+	}
+	return append([]string{}, interp...)
+}
+
+// 命令模板参数名及其校验规则
+var templateParams = make(map[string]*regexp.Regexp)
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// 解析--param定义的模板参数，每个参数都必须在命令中以{name}形式出现
+func initCommandTemplate() error {
+	for _, def := range paramDefs {
+		name, expr, ok := strings.Cut(def, "=")
+		if !ok || !placeholderPattern.MatchString("{"+name+"}") {
+			return fmt.Errorf("无效的模板参数定义: %s，格式应为 name=regex", def)
+		}
+		// 校验规则需匹配参数的完整取值
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("模板参数 %s 的校验正则无效: %v", name, err)
+		}
+		if !strings.Contains(strings.Join(commandSteps, "\n"), "{"+name+"}") {
+			return fmt.Errorf("命令中不存在模板参数: {%s}", name)
+		}
+		templateParams[name] = re
+	}
+	if readinessExec > 0 && len(templateParams) > 0 {
+		return errors.New("--readiness-exec不能与--param同时使用，试运行时没有模板参数的取值")
+	}
+	return nil
+}
+
+// 校验请求的模板参数并渲染命令，未通过--param定义的{xxx}保持原样
+func renderCommand(tmpl string, values map[string]string) (string, error) {
+	for name, value := range values {
+		re, ok := templateParams[name]
+		if !ok {
+			return "", fmt.Errorf("未定义的模板参数: %s", name)
+		}
+		if !re.MatchString(value) {
+			return "", fmt.Errorf("模板参数 %s 的取值不合法: %q", name, value)
+		}
+	}
+	for name := range templateParams {
+		if _, ok := values[name]; !ok {
+			return "", fmt.Errorf("缺少模板参数: %s", name)
+		}
+	}
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if _, ok := templateParams[name]; ok {
+			return values[name]
+		}
+		return placeholder
+	}), nil
+}
+
+// 拼接响应消息，忽略空的部分
+func joinMessage(msg, extra string) string {
+	if extra == "" {
+		return msg
+	}
+	return msg + "，" + extra
+}
+
+// --clean-env时保留的最小环境变量集合
+var minimalEnv = map[string][]string{
+	"windows": {"PATH", "PATHEXT", "SYSTEMROOT", "WINDIR", "COMSPEC", "TEMP", "TMP", "USERPROFILE", "LANG"},
+	"default": {"PATH", "HOME", "LANG"},
+}
+
+func initCleanEnv() error {
+	if !cleanEnv {
+		if len(keepEnv) > 0 {
+			logWarn("--keep-env仅在--clean-env时生效")
+		}
+		return nil
+	}
+	names := []string{}
+	for _, env := range baseEnv() {
+		name, _, _ := strings.Cut(env, "=")
+		names = append(names, name)
+	}
+	logInfo("命令不继承服务端环境变量，保留的环境变量：%s", strings.Join(names, ","))
+	return nil
+}
+
+// 命令继承的环境变量，--clean-env时仅保留最小集合及--keep-env指定的变量
+func baseEnv() []string {
+	if !cleanEnv {
+		return withChildPath(os.Environ())
+	}
+	keep, ok := minimalEnv[runtime.GOOS]
+	if !ok {
+		keep = minimalEnv["default"]
+	}
+	keep = append(append([]string{}, keep...), keepEnv.values()...)
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, k := range keep {
+			if envNameEqual(name, k) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return withChildPath(env)
+}
+
+func initEnvFile() error {
+	if envFile == "" {
+		return nil
+	}
+	env, err := loadEnvFile()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		names = append(names, name)
+	}
+	// 变量值可能是密钥，只输出变量名
+	logInfo("环境变量文件：%s，包含的环境变量：%s", envFile, strings.Join(names, ","))
+	return nil
+}
+
+// 读取--env-file指定的环境变量文件，支持#注释及export前缀
+func loadEnvFile() ([]string, error) {
+	if envFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取环境变量文件失败: %v", err)
+	}
+	var env []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			// 不输出行内容，避免泄露变量值
+			return nil, fmt.Errorf("环境变量文件%s第%d行格式错误，应为KEY=VALUE", envFile, i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// windows下环境变量名不区分大小写
+func envNameEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// 子进程可见的PATH，为空时不做调整
+var childPath string
+
+// 根据--path、--path-prepend计算子进程的PATH
+func initPath() error {
+	if pathOverride == "" && len(pathPrepend) == 0 {
+		return nil
+	}
+	current := pathOverride
+	if current == "" {
+		current = os.Getenv("PATH")
+	}
+	dirs := append(append([]string{}, pathPrepend...), current)
+	childPath = strings.Join(dirs, string(os.PathListSeparator))
+	logInfo("命令可见的PATH：%s", childPath)
+	return nil
+}
+
+// 替换环境变量中的PATH
+func withChildPath(env []string) []string {
+	if childPath == "" {
+		return env
+	}
+	result := make([]string, 0, len(env)+1)
+	pathName := "PATH"
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if envNameEqual(name, "PATH") {
+			pathName = name
+			continue
+		}
+		result = append(result, kv)
+	}
+	return append(result, pathName+"="+childPath)
+}
+
+// 在指定的PATH中查找可执行文件，找不到时原样返回交由exec处理
+func lookPathIn(file, path string) string {
+	if strings.ContainsAny(file, `/\`) {
+		return file
+	}
+	exts := []string{""}
+	if runtime.GOOS == "windows" {
+		exts = append(exts, strings.Split(strings.ToLower(os.Getenv("PATHEXT")), ";")...)
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			continue
+		}
+		for _, ext := range exts {
+			candidate := filepath.Join(dir, file+ext)
+			info, err := os.Stat(candidate)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			if runtime.GOOS == "windows" || info.Mode()&0111 != 0 {
+				return candidate
+			}
+		}
+	}
+	return file
+}
+
+// 在响应消息中附加注入的环境变量名
+func withEnvMessage(msg string, opts ExecOptions) string {
+	if len(opts.EnvNames) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s，注入环境变量：%s", msg, strings.Join(opts.EnvNames, ","))
+}
+
+// 启动时规范化允许的工作目录，目录不存在时直接报错
+func initAllowWorkdirs() error {
+	for i, dir := range allowWorkdirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("无效的工作目录 %s: %v", dir, err)
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return fmt.Errorf("允许的工作目录不存在: %s", dir)
+		}
+		allowWorkdirs[i] = real
+	}
+	return nil
+}
+
+// 校验请求的工作目录，必须为绝对路径且位于允许的目录之下
+func resolveWorkdir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	if len(allowWorkdirs) == 0 {
+		return "", errors.New("服务端未允许指定工作目录")
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("工作目录必须为绝对路径: %s", dir)
+	}
+	// 解析符号链接，防止通过链接跳出允许的目录
+	real, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", fmt.Errorf("工作目录不存在: %s", dir)
+	}
+	if info, err := os.Stat(real); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("工作目录不是有效的目录: %s", dir)
+	}
+	for _, allowed := range allowWorkdirs {
+		rel, err := filepath.Rel(allowed, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("工作目录不在允许的范围内: %s", dir)
+}
+
+// action=list返回的执行信息
+type ExecutionInfo struct {
+	ExecID     string  `json:"exec_id"`
+	Action     string  `json:"action"`
+	Command    string  `json:"command"`
+	StartTime  string  `json:"start_time"`
+	Iterations int     `json:"iterations"`
+	Delay      float64 `json:"delay"` // 秒
+	Pid        int     `json:"pid,omitempty"`
+	State      string  `json:"state,omitempty"` // 暂停的循环执行为PAUSED，上次运行时被中断的为INTERRUPTED
+	// 尚未开始的定时执行的计划执行时间，此时state为SCHEDULED
+	ScheduledAt string   `json:"scheduled_at,omitempty"`
+	Kind        string   `json:"kind,omitempty"` // running、scheduled
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func handleList(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	execLock.Lock()
+	running := make([]*Execution, 0, len(executions))
+	for _, execution := range executions {
+		running = append(running, execution)
+	}
+	for _, execution := range finishedExecutions {
+		if execution.Interrupted {
+			running = append(running, execution)
+		}
+	}
+	if params.Tag != "" {
+		running = slices.DeleteFunc(running, func(e *Execution) bool { return !hasTag(e, params.Tag) })
+	}
+	// 按开始时间排序，便于查看
+	sort.Slice(running, func(i, j int) bool { return running[i].StartTime.Before(running[j].StartTime) })
+	list := make([]ExecutionInfo, 0, len(running))
+	for _, execution := range running {
+		list = append(list, ExecutionInfo{
+			ExecID:     execution.ID,
+			Action:     execution.Action,
+			Command:    execution.Command,
+			StartTime:  execution.StartTime.Format(timeFormat),
+			Iterations: execution.Iterations,
+			Delay:      execution.Delay.Seconds(),
+			Pid:        execution.Pid,
+			Kind:       execution.kind(),
+			Tags:       execution.Tags,
+		})
+		switch {
+		case execution.Interrupted:
+			list[len(list)-1].State = "INTERRUPTED"
+		case execution.Paused:
+			list[len(list)-1].State = "PAUSED"
+		case execution.pending():
+			list[len(list)-1].State = "SCHEDULED"
+			list[len(list)-1].ScheduledAt = execution.ScheduledAt.Format(timeFormat)
+		}
+	}
+	execLock.Unlock()
+
+	sendResponse(w, list, http.StatusOK)
+}
+
+// action=schedules返回的定时执行
+type ScheduleInfo struct {
+	ExecID       string `json:"exec_id"`
+	Action       string `json:"action"`
+	Command      string `json:"command"`
+	Spec         string `json:"spec"`                     // 调度规则，如 at 2006-01-02 15:04:05
+	NextFireTime string `json:"next_fire_time,omitempty"` // 下次执行时间，已执行过的一次性定时执行为空
+	FireCount    int    `json:"fire_count"`
+	LastStatus   string `json:"last_status,omitempty"`
+	State        string `json:"state"` // SCHEDULED等待执行，RUNNING正在执行
+}
+
+// 列出尚未结束的定时执行，与action=list中正在执行的请求区分开
+func handleSchedules(w http.ResponseWriter, r *http.Request) {
+	execLock.Lock()
+	var scheduled []*Execution
+	for _, execution := range executions {
+		if execution.kind() == "scheduled" {
+			scheduled = append(scheduled, execution)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].ScheduledAt.Before(scheduled[j].ScheduledAt) })
+	list := make([]ScheduleInfo, 0, len(scheduled))
+	for _, execution := range scheduled {
+		info := ScheduleInfo{
+			ExecID:    execution.ID,
+			Action:    execution.Action,
+			Command:   execution.Command,
+			Spec:      "at " + execution.ScheduledAt.Format(timeFormat),
+			FireCount: execution.Iterations + execution.Running,
+			State:     "RUNNING",
+		}
+		if execution.pending() {
+			info.NextFireTime = execution.ScheduledAt.Format(timeFormat)
+			info.State = "SCHEDULED"
+		}
+		if execution.Last != nil {
+			info.LastStatus = execution.Last.Status
+		}
+		list = append(list, info)
+	}
+	execLock.Unlock()
+
+	sendResponse(w, list, http.StatusOK)
+}
+
+// action=status返回的执行状态
+type ExecutionStatus struct {
+	ExecID              string  `json:"exec_id"`
+	Action              string  `json:"action"`
+	Command             string  `json:"command"`
+	State               string  `json:"state"` // SCHEDULED、RUNNING、PAUSED、STOPPED、FINISHED、INTERRUPTED
+	StartedAt           string  `json:"started_at"`
+	FinishedAt          string  `json:"finished_at,omitempty"`
+	Iterations          int     `json:"iterations"`
+	Delay               float64 `json:"delay"` // 秒
+	Pid                 int     `json:"pid,omitempty"`
+	ExpiresAt           string  `json:"expires_at,omitempty"` // 循环执行max_duration到期的时间
+	LastStatus          string  `json:"last_status,omitempty"`
+	LastExecTime        string  `json:"last_exec_time,omitempty"`
+	LastOutput          string  `json:"last_output,omitempty"`
+	LastOutputTruncated bool    `json:"last_output_truncated,omitempty"`
+	// 距max_duration到期的剩余秒数
+	RemainingSeconds *int `json:"remaining_seconds,omitempty"`
+	// action=at的计划执行时间
+	ScheduledAt string `json:"scheduled_at,omitempty"`
+	// 当前等待的间隔（秒），连续失败退避及随机抖动后的实际值，执行中为空
+	NextDelay *float64 `json:"next_delay,omitempty"`
+	// fixed_rate时的overlap、正在进行的执行数，skip时跳过的次数
+	ScheduleMode      string `json:"schedule_mode,omitempty"`
+	Overlap           string `json:"overlap,omitempty"`
+	RunningIterations int    `json:"running_iterations,omitempty"`
+	SkippedTicks      *int   `json:"skipped_ticks,omitempty"`
+	// 循环执行设置了fail_threshold时的连续失败次数
+	FailThreshold       int  `json:"fail_threshold,omitempty"`
+	ConsecutiveFailures *int `json:"consecutive_failures,omitempty"`
+	// 当前这次执行的开始时间，两次执行的间隔中为空
+	CurrentIterationStartedAt string             `json:"current_iteration_started_at,omitempty"`
+	Progress                  *ExecutionProgress `json:"progress,omitempty"` // 多次执行、有限次循环执行的进度
+	Result                    *CommandResult     `json:"result,omitempty"`   // 异步多次执行、有限次循环执行完成后的汇总结果
+}
+
+// 多次执行的进度
+type ExecutionProgress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+	Failed    int `json:"failed"`
+}
+
+// action=status中last_output的最大字节数，超出时保留尾部
+const statusOutputLimit = 4096
+
+func handleStatus(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	status, exists := executionStatusLocked(params.ExecID)
+	execLock.Unlock()
+	if !exists {
+		sendError(w, "无效的exec_id", http.StatusNotFound)
+		return
+	}
+	sendResponse(w, status, http.StatusOK)
+}
+
+// 生成执行的状态，调用方需持有execLock
+func executionStatusLocked(id string) (ExecutionStatus, bool) {
+	state := "RUNNING"
+	execution, exists := executions[id]
+	if exists && execution.Paused {
+		state = "PAUSED"
+	}
+	if exists && execution.pending() {
+		state = "SCHEDULED"
+	}
+	if !exists {
+		execution, exists = finishedExecutions[id]
+		state = "FINISHED"
+		if exists && execution.Stopped {
+			state = "STOPPED"
+		}
+		if exists && execution.Interrupted {
+			state = "INTERRUPTED"
+		}
+	}
+	if !exists {
+		return ExecutionStatus{}, false
+	}
+
+	status := ExecutionStatus{
+		ExecID:     execution.ID,
+		Action:     execution.Action,
+		Command:    execution.Command,
+		State:      state,
+		StartedAt:  execution.StartTime.Format(timeFormat),
+		Iterations: execution.Iterations,
+		Delay:      execution.Delay.Seconds(),
+		Pid:        execution.Pid,
+	}
+	if !execution.FinishedAt.IsZero() {
+		status.FinishedAt = execution.FinishedAt.Format(timeFormat)
+	}
+	if !execution.ScheduledAt.IsZero() {
+		status.ScheduledAt = execution.ScheduledAt.Format(timeFormat)
+	}
+	status.ScheduleMode = execution.ScheduleMode
+	if execution.ScheduleMode == "fixed_rate" {
+		status.Overlap = execution.Overlap
+		status.RunningIterations = execution.Running
+		if execution.Overlap == "skip" {
+			skipped := execution.Skipped
+			status.SkippedTicks = &skipped
+		}
+	}
+	if execution.NextDelay > 0 && state == "RUNNING" {
+		next := execution.NextDelay.Seconds()
+		status.NextDelay = &next
+	}
+	if execution.FailThreshold > 0 {
+		failures := execution.ConsecutiveFailures
+		status.FailThreshold = execution.FailThreshold
+		status.ConsecutiveFailures = &failures
+	}
+	if !execution.ExpiresAt.IsZero() {
+		status.ExpiresAt = execution.ExpiresAt.Format(timeFormat)
+		if state == "RUNNING" || state == "PAUSED" {
+			remaining := max(int(time.Until(execution.ExpiresAt).Seconds()), 0)
+			status.RemainingSeconds = &remaining
+		}
+	}
+	if !execution.IterationStartedAt.IsZero() && state == "RUNNING" {
+		status.CurrentIterationStartedAt = execution.IterationStartedAt.Format(timeFormat)
+	}
+	if execution.Total > 0 {
+		status.Progress = &ExecutionProgress{
+			Completed: execution.Iterations,
+			Total:     execution.Total,
+			Failed:    execution.Failed,
+		}
+	}
+	status.Result = execution.Result
+	if last := execution.Last; last != nil {
+		status.LastStatus = last.Status
+		status.LastExecTime = last.ExecTime
+		status.LastOutput = last.Output
+		if len(last.Output) > statusOutputLimit {
+			status.LastOutputTruncated = true
+			out := last.Output[len(last.Output)-statusOutputLimit:]
+			// 避免截断在多字节字符中间
+			for i := 0; i < len(out) && i < utf8.UTFMax; i++ {
+				if utf8.RuneStart(out[i]) {
+					out = out[i:]
+					break
+				}
+			}
+			status.LastOutput = out
+		}
+	}
+	return status, true
+}
+
+// 查询已结束执行的结果，循环执行返回最近几次的结果（新的在前）
+func handleResult(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	defer execLock.Unlock()
+
+	execution, running := executions[params.ExecID]
+	if !running {
+		var exists bool
+		if execution, exists = finishedExecutions[params.ExecID]; !exists {
+			sendError(w, "无效的exec_id", http.StatusNotFound)
+			return
+		}
+	}
+
+	if execution.Action == "loop" {
+		limit := len(execution.Recent)
+		if params.Limit > 0 && params.Limit < limit {
+			limit = params.Limit
+		}
+		list := make([]CommandResult, 0, limit)
+		for i := len(execution.Recent) - 1; i >= 0 && len(list) < limit; i-- {
+			list = append(list, execution.Recent[i])
+		}
+		sendResponse(w, list, http.StatusOK)
+		return
+	}
+	if running {
+		sendResponse(w, CommandResult{
+			ExecID:   execution.ID,
+			Status:   "RUNNING",
+			Command:  execution.Command,
+			Message:  "执行尚未结束",
+			ExecTime: execution.StartTime.Format(timeFormat),
+		}, http.StatusAccepted)
+		return
+	}
+
+	result := execution.Result
+	if result == nil {
+		// 单次执行及被停止的多次执行返回最后一次执行的结果
+		result = execution.Last
+	}
+	if result == nil {
+		sendError(w, "执行未产生结果", http.StatusNotFound)
+		return
+	}
+	sendResponse(w, *result, http.StatusOK)
+}
+
+// action=wait未指定timeout时的等待时间及最长等待时间
+const (
+	waitDefaultTimeout = 30 * time.Second
+	waitMaxTimeout     = 5 * time.Minute
+)
+
+// 长轮询等待执行结束：timeout内结束时返回执行结果，否则返回202及当前状态，等待期间不持有execLock
+func handleWait(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+	timeout := time.Duration(params.Timeout)
+	switch {
+	case timeout < 0:
+		sendError(w, "timeout不能为负数", http.StatusBadRequest)
+		return
+	case timeout == 0:
+		timeout = waitDefaultTimeout
+	case timeout > waitMaxTimeout:
+		timeout = waitMaxTimeout
+	}
+
+	execLock.Lock()
+	execution, running := executions[params.ExecID]
+	if !running {
+		if _, exists := finishedExecutions[params.ExecID]; !exists {
+			execLock.Unlock()
+			sendError(w, "无效的exec_id", http.StatusNotFound)
+			return
+		}
+	}
+	var done chan struct{}
+	if running {
+		done = execution.Done
+	}
+	execLock.Unlock()
+
+	if running {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			execLock.Lock()
+			status, exists := executionStatusLocked(params.ExecID)
+			execLock.Unlock()
+			if !exists {
+				sendError(w, "无效的exec_id", http.StatusNotFound)
+				return
+			}
+			sendResponse(w, status, http.StatusAccepted)
+			return
+		}
+	}
+
+	var result *CommandResult
+	updateExecution(params.ExecID, func(e *Execution) {
+		result = e.Result
+		if result == nil {
+			result = e.Last
+		}
+	})
+	if result == nil {
+		sendError(w, "执行未产生结果", http.StatusNotFound)
+		return
+	}
+	sendResponse(w, *result, http.StatusOK)
+}
+
+// 执行历史中的一条记录
+type HistoryEntry struct {
+	Action string `json:"action"` // 产生该结果的执行方式：single、multiple、loop
+	Seq    uint64 `json:"-"`      // 递增的序号，用作数据文件中的键
+	CommandResult
+}
+
+var (
+	historyLock sync.Mutex
+	history     []HistoryEntry
+	historySeq  uint64
+)
+
+// 记录一次执行的结果，超出--history-size时丢弃最早的记录，设置--data-dir时同时持久化
+func addHistory(action string, result CommandResult) {
+	historyLock.Lock()
+	historySeq++
+	entry := HistoryEntry{Action: action, Seq: historySeq, CommandResult: result}
+	if historySize > 0 {
+		history = append(history, entry)
+		if len(history) > historySize {
+			history = append(history[:0:0], history[len(history)-historySize:]...)
+		}
+	}
+	historyLock.Unlock()
+	persistHistory(entry)
+}
+
+// 按从新到旧遍历执行历史，内存中没有的更早记录从数据文件中读取，fn返回false时停止
+func eachHistory(fn func(HistoryEntry) bool) {
+	historyLock.Lock()
+	entries := append([]HistoryEntry(nil), history...)
+	before := historySeq + 1
+	historyLock.Unlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !fn(entries[i]) {
+			return
+		}
+		before = entries[i].Seq
+	}
+	readStoredHistory(before, fn)
+}
+
+// 返回执行历史（新的在前），可按status、since过滤并通过limit限制数量
+func handleHistory(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	match, err := historyFilter(params)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := make([]HistoryEntry, 0)
+	eachHistory(func(entry HistoryEntry) bool {
+		if params.Limit > 0 && len(list) >= params.Limit {
+			return false
+		}
+		if match(entry) {
+			list = append(list, entry)
+		}
+		return true
+	})
+	sendResponse(w, list, http.StatusOK)
+}
+
+// 根据status、since参数生成执行历史的过滤条件
+func historyFilter(params RequestParams) (func(HistoryEntry) bool, error) {
+	var since time.Time
+	if params.Since != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, params.Since); err != nil {
+			return nil, errors.New("无效的since参数，应为RFC3339格式")
+		}
+	}
+	return func(entry HistoryEntry) bool {
+		if params.Status != "" && entry.Status != params.Status {
+			return false
+		}
+		return since.IsZero() || entry.StartTimeUnix >= since.UnixMilli()
+	}, nil
+}
+
+// 导出CSV时的列
+var exportColumns = []string{
+	"exec_id", "action", "status", "command", "exec_time", "exec_ms",
+	"exit_code", "signal", "error", "hostname", "instance", "output",
+}
+
+// 以CSV或NDJSON格式流式导出执行历史，过滤条件与action=history相同
+func handleExport(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	match, err := historyFilter(params)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := params.Format
+	if format == "" {
+		format = "csv"
+	}
+	var write func(HistoryEntry) error
+	var flush func()
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		write = func(entry HistoryEntry) error {
+			return cw.Write([]string{
+				entry.ExecID, entry.Action, entry.Status, entry.Command, entry.ExecTime,
+				strconv.FormatInt(entry.ExecMs, 10), strconv.Itoa(entry.ExitCode),
+				entry.Signal, entry.Error, hostname, instanceName, entry.Output,
+			})
+		}
+		flush = cw.Flush
+		defer flush()
+		if err := cw.Write(exportColumns); err != nil {
+			return
+		}
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		write = func(entry HistoryEntry) error {
+			entry.Hostname, entry.Instance = hostname, instanceName
+			return enc.Encode(entry)
+		}
+		flush = func() {}
+	default:
+		sendError(w, fmt.Sprintf("不支持的format: %s，可选值为csv、ndjson", format), http.StatusBadRequest)
+		return
+	}
+	filename := fmt.Sprintf("remotec-history-%s.%s", time.Now().Format("20060102150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	count := 0
+	eachHistory(func(entry HistoryEntry) bool {
+		if params.Limit > 0 && count >= params.Limit {
+			return false
+		}
+		if !match(entry) {
+			return true
+		}
+		if err := write(entry); err != nil {
+			logWarn("导出执行历史失败: %v", err)
+			return false
+		}
+		count++
+		// 定期刷新，避免整个导出内容缓存在内存中
+		if count%100 == 0 {
+			flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return true
+	})
+}
+
+// stopAll的结果，列出被停止及因不符合过滤条件而跳过的exec_id
+type StopAllResult struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Stopped []string `json:"stopped"`
+	Skipped []string `json:"skipped"`
+}
+
+// 停止所有正在执行的任务，可按执行方式、运行时长、命令内容过滤，未指定过滤条件时全部停止
+func handleStopAll(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	switch params.FilterAction {
+	case "", "single", "multiple", "loop":
+	default:
+		sendError(w, fmt.Sprintf("不支持的filter_action: %s，可选值为single、multiple、loop", params.FilterAction), http.StatusBadRequest)
+		return
+	}
+	result := StopAllResult{Status: "STOPPED_ALL", Stopped: []string{}, Skipped: []string{}}
+
+	execLock.Lock()
+	for id, execution := range executions {
+		if !matchStopFilter(execution, params) {
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+		stopExecutionLocked(execution)
+		result.Stopped = append(result.Stopped, id)
+		logInfo("已停止执行 [ExecID:%s]", id)
+	}
+	execLock.Unlock()
+
+	sort.Strings(result.Stopped)
+	sort.Strings(result.Skipped)
+	result.Message = fmt.Sprintf("已停止%d个正在执行的任务", len(result.Stopped))
+	if len(result.Skipped) > 0 {
+		result.Message += fmt.Sprintf("，跳过%d个", len(result.Skipped))
+	}
+	sendResponse(w, result, http.StatusOK)
+}
+
+func matchStopFilter(execution *Execution, params RequestParams) bool {
+	if params.FilterAction != "" && execution.Action != params.FilterAction {
+		return false
+	}
+	if params.Tag != "" && !hasTag(execution, params.Tag) {
+		return false
+	}
+	if params.OlderThan > 0 && time.Since(execution.StartTime) < time.Duration(params.OlderThan)*time.Second {
+		return false
+	}
+	return params.CommandContains == "" || strings.Contains(execution.Command, params.CommandContains)
+}
+
+func handleLoop(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	if params.MaxIterations < 0 {
+		sendError(w, "max_iterations不能为负数", http.StatusBadRequest)
+		return
+	}
+	if params.MaxDuration < 0 {
+		sendError(w, "max_duration不能为负数", http.StatusBadRequest)
+		return
+	}
+	if params.FailThreshold < 0 {
+		sendError(w, "fail_threshold不能为负数", http.StatusBadRequest)
+		return
+	}
+	if params.Jitter.Duration < 0 || params.Jitter.Percent < 0 {
+		sendError(w, "jitter不能为负数", http.StatusBadRequest)
+		return
+	}
+	if params.BackoffFactor != 0 && params.BackoffFactor < 1 {
+		sendError(w, "backoff_factor不能小于1", http.StatusBadRequest)
+		return
+	}
+	if params.BackoffMax < 0 {
+		sendError(w, "backoff_max不能为负数", http.StatusBadRequest)
+		return
+	}
+	switch params.Overlap {
+	case "", "wait", "skip", "concurrent":
+	default:
+		sendError(w, "无效的overlap，可选值：wait、skip、concurrent", http.StatusBadRequest)
+		return
+	}
+	scheduleMode := params.ScheduleMode
+	switch scheduleMode {
+	case "":
+		// skip、concurrent只在按间隔定时执行时有意义
+		scheduleMode = "fixed_delay"
+		if params.Overlap == "skip" || params.Overlap == "concurrent" {
+			scheduleMode = "fixed_rate"
+		}
+	case "fixed_delay":
+		if params.Overlap == "skip" || params.Overlap == "concurrent" {
+			sendError(w, "overlap为skip、concurrent时schedule_mode须为fixed_rate", http.StatusBadRequest)
+			return
+		}
+	case "fixed_rate":
+	default:
+		sendError(w, "无效的schedule_mode，可选值：fixed_delay、fixed_rate", http.StatusBadRequest)
+		return
+	}
+	if scheduleMode == "fixed_rate" && params.Delay <= 0 {
+		sendError(w, "fixed_rate时delay必须大于0", http.StatusBadRequest)
+		return
+	}
+	var untilMatch *regexp.Regexp
+	if params.UntilMatch != "" {
+		re, err := regexp.Compile(params.UntilMatch)
+		if err != nil {
+			sendError(w, fmt.Sprintf("无效的until_match正则: %v", err), http.StatusBadRequest)
+			return
+		}
+		untilMatch = re
+	}
+	delay := time.Duration(params.Delay)
+	execID := requestExecID(params)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !registerExecution(execID, "loop", opts.Command, params, cancel) {
+		cancel()
+		sendAlreadyRunning(w, execID)
+		return
+	}
+
+	// 达到max_duration时整个循环执行结束，与stop的取消区分开
+	loopCtx, cancelLoop := ctx, context.CancelFunc(func() {})
+	var expiresAt time.Time
+	if params.MaxDuration > 0 {
+		expiresAt = time.Now().Add(time.Duration(params.MaxDuration))
+		loopCtx, cancelLoop = context.WithDeadline(ctx, expiresAt)
+	}
+	updateExecution(execID, func(e *Execution) {
+		e.Total, e.Delay, e.ExpiresAt = params.MaxIterations, delay, expiresAt
+		e.FailThreshold = params.FailThreshold
+		e.UntilMatch, e.UntilExitZero = untilMatch, params.UntilExitZero
+		e.Jitter = params.Jitter
+		e.BackoffFactor, e.BackoffMax = params.BackoffFactor, time.Duration(params.BackoffMax)
+		e.ScheduleMode, e.Overlap = scheduleMode, params.Overlap
+	})
+	persistExecution(ExecutionInfo{
+		ExecID:    execID,
+		Action:    "loop",
+		Command:   opts.Command,
+		StartTime: time.Now().Format(timeFormat),
+	})
+
+	go func() {
+		defer cancel()
+		defer cancelLoop()
+		defer cleanExecution(execID)
+		startTime := time.Now()
+		status := runLoop(loopCtx, execID, opts)
+		if status == "" {
+			if !errors.Is(loopCtx.Err(), context.DeadlineExceeded) {
+				return
+			}
+			logInfo("循环执行达到最长运行时间%s [ExecID:%s]", time.Duration(params.MaxDuration), execID)
+			status = "EXPIRED"
+		}
+		summary := loopSummary(execID, opts, startTime, status)
+		logJSON(summary)
+		updateExecution(execID, func(e *Execution) { e.Result = &summary })
+	}()
+
+	sendResponse(w, CommandResult{
+		ExecID:   execID,
+		Status:   "STARTED",
+		Command:  opts.Command,
+		Message:  withEnvMessage(loopDescription(params), opts),
+		ExecTime: time.Now().Format(timeFormat),
+		Workdir:  opts.Workdir,
+		Env:      opts.EnvNames,
+		Args:     opts.Args,
+	}, http.StatusOK)
+}
+
+// 循环执行的描述，用于响应及日志
+func loopDescription(params RequestParams) string {
+	message := fmt.Sprintf("循环执行，间隔：%s", formatDelay(time.Duration(params.Delay)))
+	if params.MaxIterations > 0 {
+		message += fmt.Sprintf("，最多执行：%d次", params.MaxIterations)
+	}
+	if params.MaxDuration > 0 {
+		message += fmt.Sprintf("，最长运行：%s", time.Duration(params.MaxDuration))
+	}
+	if params.FailThreshold > 0 {
+		message += fmt.Sprintf("，连续失败%d次时停止", params.FailThreshold)
+	}
+	if params.Jitter != (JitterParam{}) {
+		message += fmt.Sprintf("，随机抖动：%s", params.Jitter)
+	}
+	if params.ScheduleMode == "fixed_rate" {
+		message += "，按固定频率执行"
+	}
+	switch params.Overlap {
+	case "skip":
+		message += "，上一次执行未结束时跳过"
+	case "concurrent":
+		message += "，上一次执行未结束时并发执行"
+	}
+	if params.BackoffFactor > 1 {
+		message += fmt.Sprintf("，失败退避倍数：%g", params.BackoffFactor)
+		if params.BackoffMax > 0 {
+			message += fmt.Sprintf("，最长间隔：%s", time.Duration(params.BackoffMax))
+		}
+	}
+	if params.UntilMatch != "" {
+		message += fmt.Sprintf("，输出匹配%s时结束", params.UntilMatch)
+	}
+	if params.UntilExitZero {
+		message += "，执行成功时结束"
+	}
+	return message
+}
+
+// 按间隔循环执行命令，直到被停止、满足结束条件、达到max_iterations或连续失败达到fail_threshold
+// 返回汇总结果的status，被停止时返回空字符串
+func runLoop(ctx context.Context, execID string, opts ExecOptions) string {
+	var trigger chan chan CommandResult
+	var scheduleMode, overlap string
+	updateExecution(execID, func(e *Execution) {
+		trigger, scheduleMode, overlap = e.Trigger, e.ScheduleMode, e.Overlap
+	})
+	if scheduleMode == "fixed_rate" {
+		return runLoopFixedRate(ctx, execID, opts, trigger, overlap)
+	}
+	for {
+		if status := loopEndStatus(execID); status != "" {
+			return status
+		}
+		if !waitWhilePaused(ctx, execID, opts, trigger) {
+			return ""
+		}
+		select {
+		case <-ctx.Done():
+			return ""
+		default:
+			runIteration(ctx, execID, opts)
+			// 已满足结束条件时不再等待间隔
+			if status := loopEndStatus(execID); status != "" {
+				return status
+			}
+			sleep := nextLoopDelay(execID)
+			updateExecution(execID, func(e *Execution) { e.NextDelay = sleep })
+			ok := loopSleep(ctx, execID, opts, trigger, sleep)
+			updateExecution(execID, func(e *Execution) { e.NextDelay = 0 })
+			if !ok {
+				return ""
+			}
+		}
+	}
+}
+
+// 按固定频率执行：每次开始时间按上一次的计划开始时间加间隔计算，不随执行耗时漂移
+// 到点时上一次执行未结束：wait等待结束后立即开始，skip跳过本次，concurrent同时执行
+// 停止时取消所有正在进行的执行，全部结束后才返回
+func runLoopFixedRate(ctx context.Context, execID string, opts ExecOptions, trigger chan chan CommandResult, overlap string) string {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	next := time.Now()
+	for {
+		if status := loopEndStatus(execID); status != "" {
+			wg.Wait()
+			return status
+		}
+		if !waitWhilePaused(ctx, execID, opts, trigger) {
+			return ""
+		}
+		// 暂停恢复后从当前时间重新开始计时
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+		if !loopSleep(ctx, execID, opts, trigger, time.Until(next)) {
+			return ""
+		}
+
+		var running, iterations, maxIterations int
+		updateExecution(execID, func(e *Execution) {
+			running, iterations, maxIterations = e.Running, e.Iterations, e.Total
+		})
+		switch {
+		case maxIterations > 0 && iterations+running >= maxIterations:
+			// 剩余次数已由正在进行的执行占满，等待其结束后再检查结束条件
+			wg.Wait()
+			continue
+		case overlap == "skip" && running > 0:
+			updateExecution(execID, func(e *Execution) { e.Skipped++ })
+			logInfo("上一次执行尚未结束，跳过本次执行 [ExecID:%s]", execID)
+		case overlap != "concurrent":
+			runIteration(ctx, execID, opts)
+			if ctx.Err() != nil {
+				return ""
+			}
+		default:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runIteration(ctx, execID, opts)
+			}()
+		}
+
+		sleep := nextLoopDelay(execID)
+		updateExecution(execID, func(e *Execution) { e.NextDelay = sleep })
+		next = next.Add(sleep)
+		// 执行超过间隔时不补执行错过的次数，下一次立即开始并从此重新计时
+		if now := time.Now(); next.Before(now) {
+			logInfo("循环执行耗时超过间隔，立即开始下一次执行 [ExecID:%s]", execID)
+			next = now
+		}
+	}
+}
+
+// 计算下一次等待的间隔：连续失败时按backoff_factor退避，再叠加随机抖动
+// 每次等待前读取间隔，action=update调整后从下一次等待开始生效
+func nextLoopDelay(execID string) time.Duration {
+	var delay time.Duration
+	var jitter JitterParam
+	var factor float64
+	var backoffMax time.Duration
+	var failures int
+	updateExecution(execID, func(e *Execution) {
+		delay, jitter = e.Delay, e.Jitter
+		factor, backoffMax, failures = e.BackoffFactor, e.BackoffMax, e.ConsecutiveFailures
+	})
+	if factor > 1 && failures > 0 {
+		backoff := float64(delay) * math.Pow(factor, float64(failures))
+		if backoffMax > 0 && backoff > float64(backoffMax) {
+			backoff = float64(backoffMax)
+		}
+		delay = time.Duration(backoff)
+		logInfo("循环执行连续失败%d次，下次间隔：%s [ExecID:%s]", failures, delay, execID)
+	}
+	if jitter != (JitterParam{}) {
+		sleep := applyJitter(delay, jitter)
+		logDebug("循环执行间隔抖动：%s，本次间隔：%s [ExecID:%s]", sleep-delay, sleep, execID)
+		delay = sleep
+	}
+	return delay
+}
+
+// 检查循环执行的结束条件，满足时返回汇总结果的status，否则返回空字符串
+func loopEndStatus(execID string) string {
+	var iterations, maxIterations, failures, threshold int
+	var conditionMet bool
+	updateExecution(execID, func(e *Execution) {
+		iterations, maxIterations = e.Iterations, e.Total
+		failures, threshold = e.ConsecutiveFailures, e.FailThreshold
+		conditionMet = e.ConditionMet
+	})
+	switch {
+	case conditionMet:
+		logInfo("循环执行满足结束条件 [ExecID:%s]", execID)
+		return "CONDITION_MET"
+	case threshold > 0 && failures >= threshold:
+		logWarn("循环执行连续失败%d次，已自动停止 [ExecID:%s]", failures, execID)
+		return "STOPPED_ON_FAILURES"
+	case maxIterations > 0 && iterations >= maxIterations:
+		logInfo("循环执行达到最大执行次数%d [ExecID:%s]", maxIterations, execID)
+		return "COMPLETED"
+	}
+	return ""
+}
+
+// 循环执行结束后的汇总结果，包含总执行次数、失败次数及总耗时
+// status为COMPLETED（达到max_iterations）、EXPIRED（达到max_duration）、
+// STOPPED_ON_FAILURES（达到fail_threshold）或CONDITION_MET（满足until_match、until_exit_zero）
+func loopSummary(execID string, opts ExecOptions, startTime time.Time, status string) CommandResult {
+	var iterations, failed int
+	var last CommandResult
+	updateExecution(execID, func(e *Execution) {
+		iterations, failed = e.Iterations, e.Failed
+		if e.Last != nil {
+			last = *e.Last
+		}
+	})
+	shapeOutput(&last, opts)
+	message := fmt.Sprintf("循环执行结束，共执行：%d次，失败：%d次", iterations, failed)
+	switch status {
+	case "EXPIRED":
+		message = "达到最长运行时间，" + message
+	case "STOPPED_ON_FAILURES":
+		message = "连续失败次数达到上限，" + message
+	case "CONDITION_MET":
+		message = "满足结束条件，" + message
+	}
+	summary := CommandResult{
+		ExecID:     execID,
+		Status:     status,
+		Command:    opts.Command,
+		Message:    withEnvMessage(message, opts),
+		ExitCode:   last.ExitCode,
+		Pid:        last.Pid,
+		Output:     last.Output,
+		Stdout:     last.Stdout,
+		Stderr:     last.Stderr,
+		Workdir:    last.Workdir,
+		Env:        last.Env,
+		Args:       last.Args,
+		OutputFile: last.OutputFile,
+	}
+	summary.setTiming(startTime, time.Now())
+	return summary
+}
+
+// 等待循环执行的间隔，期间收到action=trigger时立即执行一次，不影响原有的间隔，被停止时返回false
+func loopSleep(ctx context.Context, execID string, opts ExecOptions, trigger chan chan CommandResult, d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case reply := <-trigger:
+			runTriggered(ctx, execID, opts, reply)
+		default:
+		}
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case reply := <-trigger:
+			runTriggered(ctx, execID, opts, reply)
+		}
+	}
+}
+
+// 执行一次由action=trigger触发的额外执行，结果返回给请求方
+func runTriggered(ctx context.Context, execID string, opts ExecOptions, reply chan CommandResult) {
+	logInfo("立即执行一次循环命令 [ExecID:%s]", execID)
+	reply <- runIteration(ctx, execID, opts)
+}
+
+// 执行循环中的一次命令并记录结果
+func runIteration(ctx context.Context, execID string, opts ExecOptions) CommandResult {
+	startIteration(execID)
+	result := executeCommand(ctx, execID, opts)
+	// 因达到max_duration被中断的那次执行与主动停止一样不计入完成次数
+	if result.Status == "TIMEOUT" && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.Status = "EXPIRED"
+		result.Message = "达到最长运行时间，已停止执行"
+	}
+	recordIteration(execID, result)
+	updateExecution(execID, func(e *Execution) {
+		if e.UntilMatch == nil && !e.UntilExitZero {
+			return
+		}
+		if result.Status == "CANCELED" || result.Status == "EXPIRED" {
+			return
+		}
+		if e.UntilMatch != nil && !e.UntilMatch.MatchString(result.Output) {
+			return
+		}
+		if e.UntilExitZero && result.Status != "COMPLETED" {
+			return
+		}
+		e.ConditionMet = true
+	})
+	return result
+}
+
+// 循环执行被暂停时等待恢复，暂停期间仍响应action=trigger，被停止时返回false
+func waitWhilePaused(ctx context.Context, execID string, opts ExecOptions, trigger chan chan CommandResult) bool {
+	for {
+		var paused bool
+		var wake chan struct{}
+		updateExecution(execID, func(e *Execution) { paused, wake = e.Paused, e.Wake })
+		if !paused {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+		case reply := <-trigger:
+			runTriggered(ctx, execID, opts, reply)
+		}
+	}
+}
+
+// 让循环执行立即执行一次并同步返回结果，之后按原有的间隔继续
+func handleTrigger(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	execution, exists := executions[params.ExecID]
+	if !exists {
+		execLock.Unlock()
+		sendError(w, "无效的exec_id", http.StatusNotFound)
+		return
+	}
+	if execution.Action != "loop" {
+		execLock.Unlock()
+		sendError(w, "只能触发循环执行", http.StatusConflict)
+		return
+	}
+	if !execution.IterationStartedAt.IsZero() {
+		execLock.Unlock()
+		sendError(w, "循环执行正在执行中", http.StatusConflict)
+		return
+	}
+	reply := make(chan CommandResult, 1)
+	select {
+	case execution.Trigger <- reply:
+	default:
+		execLock.Unlock()
+		sendError(w, "已有等待执行的触发请求", http.StatusConflict)
+		return
+	}
+	done := execution.Done
+	execLock.Unlock()
+
+	select {
+	case result := <-reply:
+		result.Message = joinMessage("立即执行", result.Message)
+		sendResponse(w, result, http.StatusOK)
+	case <-done:
+		sendError(w, "循环执行已结束", http.StatusConflict)
+	case <-r.Context().Done():
+	}
+}
+
+// 暂停或恢复循环执行，暂停时当前这次执行会正常结束
+func handlePause(w http.ResponseWriter, r *http.Request, params RequestParams, pause bool) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	execution, exists := executions[params.ExecID]
+	if !exists {
+		execLock.Unlock()
+		sendError(w, "无效的exec_id", http.StatusNotFound)
+		return
+	}
+	if execution.Action != "loop" {
+		execLock.Unlock()
+		sendError(w, "只能暂停或恢复循环执行", http.StatusConflict)
+		return
+	}
+	if execution.Paused == pause {
+		execLock.Unlock()
+		if pause {
+			sendError(w, "循环执行已处于暂停状态", http.StatusConflict)
+		} else {
+			sendError(w, "循环执行未暂停", http.StatusConflict)
+		}
+		return
+	}
+	execution.Paused = pause
+	select {
+	case execution.Wake <- struct{}{}:
+	default:
+	}
+	command := execution.Command
+	execLock.Unlock()
+
+	result := CommandResult{ExecID: params.ExecID, Status: "PAUSED", Command: command, Message: "循环执行已暂停"}
+	if !pause {
+		result.Status, result.Message = "RESUMED", "循环执行已恢复"
+	}
+	logInfo("%s [ExecID:%s]", result.Message, params.ExecID)
+	sendResponse(w, result, http.StatusOK)
+}
+
+// action=update的结果，返回调整前后的间隔
+type UpdateResult struct {
+	ExecID   string  `json:"exec_id"`
+	Status   string  `json:"status"`
+	Message  string  `json:"message"`
+	OldDelay float64 `json:"old_delay"` // 秒
+	NewDelay float64 `json:"new_delay"`
+}
+
+// 调整循环执行的间隔，从下一次等待开始生效
+func handleUpdate(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+	if params.Delay < 0 {
+		sendError(w, "delay不能为负数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	execution, exists := executions[params.ExecID]
+	if !exists {
+		execLock.Unlock()
+		sendError(w, "无效的exec_id", http.StatusNotFound)
+		return
+	}
+	if execution.Action != "loop" {
+		execLock.Unlock()
+		sendError(w, "只能调整循环执行的间隔", http.StatusConflict)
+		return
+	}
+	oldDelay, newDelay := execution.Delay, time.Duration(params.Delay)
+	execution.Delay = newDelay
+	execLock.Unlock()
+
+	result := UpdateResult{ExecID: params.ExecID, Status: "UPDATED", OldDelay: oldDelay.Seconds(), NewDelay: newDelay.Seconds()}
+	result.Message = fmt.Sprintf("循环执行间隔已由%s调整为%s", formatDelay(oldDelay), formatDelay(newDelay))
+	logInfo("%s [ExecID:%s]", result.Message, params.ExecID)
+	sendResponse(w, result, http.StatusOK)
+}
+
+// 多次执行的选项
+type MultipleOptions struct {
+	Count    int
+	Delay    time.Duration
+	Collect  bool // 汇总结果中包含每次执行的结果
+	Parallel int  // 同时执行的数量，大于1时汇总结果中总是包含每次执行的结果
+	FailFast bool // 第一次执行失败时中止剩余的执行
+}
+
+func handleMultiple(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	count := max(params.Count, 1)
+	delay := time.Duration(params.Delay)
+	if params.Parallel < 0 {
+		sendError(w, "parallel不能为负数", http.StatusBadRequest)
+		return
+	}
+	mopts := MultipleOptions{
+		Count:    count,
+		Delay:    delay,
+		Collect:  params.Collect,
+		Parallel: min(params.Parallel, count),
+		FailFast: params.FailFast,
+	}
+	execID := requestExecID(params)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if !registerExecution(execID, "multiple", opts.Command, params, cancel) {
+		cancel()
+		sendAlreadyRunning(w, execID)
+		return
+	}
+	updateExecution(execID, func(e *Execution) { e.Total, e.Delay = count, delay })
+
+	if params.Async {
+		// 异步执行立即返回exec_id，进度及汇总结果通过action=status查询
+		go func() {
+			defer cancel()
+			defer cleanExecution(execID)
+			if summary, ok := runMultiple(ctx, execID, opts, mopts); ok {
+				logJSON(summary)
+				updateExecution(execID, func(e *Execution) { e.Result = &summary })
+			}
+		}()
+		sendResponse(w, CommandResult{
+			ExecID:   execID,
+			Status:   "STARTED",
+			Command:  opts.Command,
+			Message:  withEnvMessage(fmt.Sprintf("异步多次执行，次数：%d，间隔：%s", count, formatDelay(delay)), opts),
+			ExecTime: time.Now().Format(timeFormat),
+			Workdir:  opts.Workdir,
+			Env:      opts.EnvNames,
+			Args:     opts.Args,
+		}, http.StatusAccepted)
+		return
+	}
+	defer cleanExecution(execID)
+
+	summary, ok := runMultiple(ctx, execID, opts, mopts)
+	if !ok {
+		return
+	}
+	updateExecution(execID, func(e *Execution) { e.Result = &summary })
+	sendResponse(w, summary, http.StatusOK)
+}
+
+// 按次数执行命令，返回汇总结果，被停止时返回false
+func runMultiple(ctx context.Context, execID string, opts ExecOptions, mopts MultipleOptions) (CommandResult, bool) {
+	count, delay := mopts.Count, mopts.Delay
+	startTime := time.Now()
+	// fail_fast中止时只取消runCtx，与stop、stopAll取消执行的ctx区分开
+	runCtx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	// 并发执行时各次执行的结束顺序不确定，按序号记录
+	var mu sync.Mutex
+	var result, failure CommandResult
+	var results []CommandResult
+	last, completed, succeeded, failedAt := -1, 0, 0, -1
+	collect := mopts.Collect || mopts.Parallel > 1
+	if collect {
+		results = make([]CommandResult, count)
+	}
+	record := func(i int, r CommandResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Status != "CANCELED" {
+			completed++
+		}
+		if r.Status == "COMPLETED" {
+			succeeded++
+		}
+		if i > last {
+			result, last = r, i
+		}
+		if mopts.FailFast && failedAt < 0 && r.Status != "COMPLETED" && r.Status != "CANCELED" {
+			failure, failedAt = r, i
+			logWarn("第%d次执行失败，中止剩余的执行 [ExecID:%s]", i+1, execID)
+			abort()
+		}
+		if collect {
+			// 每次执行的输出已受--max-output-bytes限制，再按tail、grep裁剪
+			shapeOutput(&r, opts)
+			results[i] = r
+		}
+	}
+
+	if mopts.Parallel > 1 {
+		runMultipleParallel(runCtx, execID, opts, mopts, record)
+	} else {
+		for i := 0; i < count && runCtx.Err() == nil; i++ {
+			if i > 0 && delay > 0 {
+				select {
+				case <-runCtx.Done():
+					continue
+				case <-time.After(delay):
+				}
+			}
+			startIteration(execID)
+			r := executeCommand(runCtx, execID, opts)
+			recordIteration(execID, r)
+			record(i, r)
+		}
+	}
+	if ctx.Err() != nil {
+		logInfo("多次执行已停止，已完成%d次 [ExecID:%s]", completed, execID)
+		return result, false
+	}
+
+	status := "COMPLETED"
+	message := fmt.Sprintf("多次执行，次数：%d，间隔：%s", count, formatDelay(delay))
+	if mopts.Parallel > 1 {
+		message += fmt.Sprintf("，并发数：%d", mopts.Parallel)
+	}
+	if failedAt >= 0 {
+		// 返回失败的那次执行的输出
+		status, result = "ABORTED", failure
+		message = joinMessage(message, fmt.Sprintf("第%d次执行失败，已中止剩余的执行，已完成%d次", failedAt+1, completed))
+	}
+	shapeOutput(&result, opts)
+	summary := CommandResult{
+		ExecID:       execID,
+		Status:       status,
+		Command:      opts.Command,
+		Message:      withEnvMessage(message, opts),
+		ExitCode:     result.ExitCode,
+		Pid:          result.Pid,
+		Output:       result.Output,
+		Stdout:       result.Stdout,
+		Stderr:       result.Stderr,
+		Workdir:      result.Workdir,
+		Env:          result.Env,
+		Args:         result.Args,
+		User:         result.User,
+		Priority:     result.Priority,
+		MatchedLines: result.MatchedLines,
+		Steps:        result.Steps,
+		OutputFile:   result.OutputFile,
+		Signal:       result.Signal,
+		OnFailure:    result.OnFailure,
+	}
+	if collect {
+		failed := completed - succeeded
+		if failedAt >= 0 {
+			// 中止时未执行及被取消的不计入
+			results = slices.DeleteFunc(results, func(r CommandResult) bool { return r.Status == "" })
+		}
+		summary.Results = results
+		summary.Succeeded = &succeeded
+		summary.Failed = &failed
+	}
+	// 并发执行时为从开始到全部结束的总耗时
+	summary.setTiming(startTime, time.Now())
+	return summary, true
+}
+
+// 按并发数同时执行，间隔作用于相邻两次执行的开始之间，停止时取消所有正在进行的执行
+func runMultipleParallel(ctx context.Context, execID string, opts ExecOptions, mopts MultipleOptions, record func(int, CommandResult)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	workers := make(chan struct{}, mopts.Parallel)
+	for i := 0; i < mopts.Count; i++ {
+		if i > 0 && mopts.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(mopts.Delay):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			iterCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			startIteration(execID)
+			r := executeCommand(iterCtx, execID, opts)
+			recordIteration(execID, r)
+			record(i, r)
+		}(i)
+	}
+}
+
+// 按请求参数裁剪响应中的输出，完整输出仍记录在日志中
+func shapeOutput(result *CommandResult, opts ExecOptions) {
+	if opts.Grep != nil {
+		var matched int
+		result.Output, matched = grepLines(result.Output, opts.Grep)
+		result.MatchedLines = &matched
+	}
+	result.Output = tailLines(result.Output, opts.Tail)
+}
+
+// 仅保留匹配正则的行
+func grepLines(s string, re *regexp.Regexp) (string, int) {
+	var b strings.Builder
+	matched := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if re.MatchString(strings.TrimRight(line, "\r\n")) {
+			b.WriteString(line)
+			matched++
+		}
+	}
+	return b.String(), matched
+}
+
+// 返回最后n行，n<=0或超过总行数时返回全部内容
+func tailLines(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	body := strings.TrimSuffix(s, "\n")
+	idx := len(body)
+	for i := 0; i < n; i++ {
+		idx = strings.LastIndexByte(body[:idx], '\n')
+		if idx < 0 {
+			return s
+		}
+	}
+	return s[idx+1:]
+}
+
+// 试运行：返回将要执行的命令详情，不启动任何进程
+func handleDryRun(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	var message string
+	switch params.Action {
+	case "multiple":
+		message = fmt.Sprintf("试运行，多次执行，次数：%d，间隔：%s", max(params.Count, 1), formatDelay(time.Duration(params.Delay)))
+	case "loop":
+		message = "试运行，" + loopDescription(params)
+	case "at":
+		message = fmt.Sprintf("试运行，定时执行，执行时间：%s", params.RunAt.Local().Format(timeFormat))
+	default:
+		message = "试运行，单次执行"
+	}
+	invocation := &Invocation{
+		Workdir:    opts.Workdir,
+		Env:        opts.EnvNames,
+		StdinBytes: len(opts.Stdin),
+		User:       runAs,
+		Priority:   priorityDesc(),
+		OnFailure:  onFailure,
+	}
+	if len(opts.Steps) > 0 {
+		for _, step := range opts.Steps {
+			invocation.Steps = append(invocation.Steps, commandArgv(step))
+		}
+	} else {
+		invocation.Argv = commandArgv(opts)
+	}
+	sendResponse(w, CommandResult{
+		Status:     "DRY_RUN",
+		Command:    opts.Command,
+		Message:    withEnvMessage(message, opts),
+		ExecTime:   time.Now().Format(timeFormat),
+		Invocation: invocation,
+	}, http.StatusOK)
+}
+
+func handleStop(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if len(params.ExecIDs) > 0 {
+		handleBatchStop(w, r, params)
+		return
+	}
+	execID := params.ExecID
+	if execID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	execution, exists := executions[execID]
+	if !exists {
+		execLock.Unlock()
+		sendError(w, "无效的exec_id", http.StatusNotFound)
+		return
+	}
+	stopExecutionLocked(execution)
+	result := CommandResult{ExecID: execID, Status: "STOPPED"}
+	if execution.Output != nil {
+		result.PartialOutput = decodeOutput(execution.Output.Bytes())
+	}
+	done := execution.Done
+	execLock.Unlock()
+	logInfo("已停止执行 [ExecID:%s]", execID)
+
+	if !params.Wait {
+		sendResponse(w, result, http.StatusOK)
+		return
+	}
+
+	// 等待执行协程结束、命令进程被回收后返回最终结果
+	timeout := stopWaitTimeout
+	if params.Timeout > 0 {
+		timeout = time.Duration(params.Timeout)
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		result.Status = "STOPPING"
+		result.Message = "已请求停止，命令仍在退出中"
+		sendResponse(w, result, http.StatusAccepted)
+		return
+	}
+
+	execLock.Lock()
+	last := execution.Last
+	execLock.Unlock()
+	if last != nil {
+		result = *last
+		result.Status = "STOPPED"
+	}
+	if result.Message == "" {
+		result.Message = "已停止执行"
+	}
+	// exec_second等为整个执行从开始到完全结束的时间
+	result.setTiming(execution.StartTime, time.Now())
+	sendResponse(w, result, http.StatusOK)
+}
+
+// 批量停止的结果，results中每个exec_id对应stopped或not_found
+type BatchStopResult struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Results map[string]string `json:"results"`
+}
+
+// 按exec_ids批量停止，部分exec_id不存在时仍返回200及每个exec_id的处理结果
+func handleBatchStop(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	result := BatchStopResult{Status: "STOPPED", Results: make(map[string]string)}
+	stopped := 0
+
+	execLock.Lock()
+	for _, id := range params.ExecIDs {
+		if execution, exists := executions[id]; exists {
+			stopExecutionLocked(execution)
+			result.Results[id] = "stopped"
+			stopped++
+		} else if _, done := result.Results[id]; !done {
+			result.Results[id] = "not_found"
+		}
+	}
+	execLock.Unlock()
+
+	for id, state := range result.Results {
+		if state == "stopped" {
+			logInfo("已停止执行 [ExecID:%s]", id)
+		}
+	}
+	result.Message = fmt.Sprintf("已停止%d个，%d个不存在", stopped, len(result.Results)-stopped)
+	sendResponse(w, result, http.StatusOK)
+}
+
+// 取消执行并移入已结束列表，调用方需持有execLock
+func stopExecutionLocked(execution *Execution) {
+	execution.Cancel()
+	execution.Stopped = true
+	finishExecutionLocked(execution)
+}
+
+// action=stop等待执行结束的默认超时时间
+const stopWaitTimeout = 30 * time.Second
+
+func handleSingle(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	startTime := time.Now()
+	execID := requestExecID(params)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if !registerExecution(execID, "single", opts.Command, params, cancel) {
+		cancel()
+		sendAlreadyRunning(w, execID)
+		return
+	}
+	if params.Async {
+		// 异步执行立即返回exec_id，结果通过action=status查询
+		go func() {
+			defer cancel()
+			result := executeWithRetry(ctx, execID, opts, params.Retries, time.Duration(params.RetryDelay))
+			recordIteration(execID, result)
+			cleanExecution(execID)
+		}()
+		sendResponse(w, CommandResult{
+			ExecID:   execID,
+			Status:   "STARTED",
+			Command:  opts.Command,
+			Message:  withEnvMessage("异步单次执行", opts),
+			ExecTime: startTime.Format(timeFormat),
+			Workdir:  opts.Workdir,
+			Env:      opts.EnvNames,
+			Args:     opts.Args,
+		}, http.StatusAccepted)
+		return
+	}
+	defer cancel()
+
+	result := executeWithRetry(ctx, execID, opts, params.Retries, time.Duration(params.RetryDelay))
+	recordIteration(execID, result)
+	cleanExecution(execID)
+
+	// 单次执行直接返回本次执行结果，status可区分执行失败、未能启动等情况
+	shapeOutput(&result, opts)
+	result.Message = joinMessage(withEnvMessage("单次执行", opts), result.Message)
+	result.setTiming(startTime, time.Now())
+	sendResponse(w, result, http.StatusOK)
+}
+
+// 定时执行：在run_at指定的时间执行一次，执行前可通过action=stop取消
+func handleAt(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	if params.RunAt.IsZero() {
+		sendError(w, "缺少run_at参数", http.StatusBadRequest)
+		return
+	}
+	runAt := params.RunAt.Time
+	if !runAt.After(time.Now()) {
+		sendError(w, "run_at不能早于当前时间", http.StatusBadRequest)
+		return
+	}
+	execID := requestExecID(params)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if !registerExecution(execID, "at", opts.Command, params, cancel) {
+		cancel()
+		sendAlreadyRunning(w, execID)
+		return
+	}
+	updateExecution(execID, func(e *Execution) { e.Kind, e.ScheduledAt = "scheduled", runAt })
+	logInfo("定时执行将于%s开始 [ExecID:%s]", runAt.Local().Format(timeFormat), execID)
+
+	go func() {
+		defer cancel()
+		defer cleanExecution(execID)
+		timer := time.NewTimer(time.Until(runAt))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			logInfo("定时执行在开始前被停止 [ExecID:%s]", execID)
+			return
+		case <-timer.C:
+		}
+		startIteration(execID)
+		result := executeWithRetry(ctx, execID, opts, params.Retries, time.Duration(params.RetryDelay))
+		recordIteration(execID, result)
+	}()
+
+	sendResponse(w, CommandResult{
+		ExecID:   execID,
+		Status:   "SCHEDULED",
+		Command:  opts.Command,
+		Message:  withEnvMessage(fmt.Sprintf("定时执行，执行时间：%s", runAt.Local().Format(timeFormat)), opts),
+		ExecTime: time.Now().Format(timeFormat),
+		Workdir:  opts.Workdir,
+		Env:      opts.EnvNames,
+		Args:     opts.Args,
+	}, http.StatusAccepted)
+}
+
+// 执行失败时按retries重试，成功或被停止时立即结束
+func executeWithRetry(ctx context.Context, execID string, opts ExecOptions, retries int, retryDelay time.Duration) CommandResult {
+	if retries <= 0 {
+		return executeCommand(ctx, execID, opts)
+	}
+	for attempt := 1; ; attempt++ {
+		opts.Attempt = attempt
+		result := executeCommand(ctx, execID, opts)
+		if result.Status == "COMPLETED" || result.Status == "CANCELED" || attempt > retries {
+			return result
+		}
+		logWarn("执行失败，%s后进行第%d次尝试 [ExecID:%s]", formatDelay(retryDelay), attempt+1, execID)
+		select {
+		case <-ctx.Done():
+			logInfo("重试已停止 [ExecID:%s]", execID)
+			result.Message = joinMessage(result.Message, "重试已被停止")
+			return result
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// 执行命令，失败时按--on-failure执行处理命令并将其结果附加到on_failure中
+func executeCommand(ctx context.Context, execID string, opts ExecOptions) CommandResult {
+	var result CommandResult
+	if len(opts.Steps) > 0 {
+		result = runSteps(ctx, execID, opts)
+	} else {
+		result = runCommand(ctx, execID, opts)
+	}
+	execStats.record(result)
+	if onFailure == "" || !needsFailureHandling(result.Status) {
+		return result
+	}
+
+	fallback := opts
+	fallback.Command = onFailure
+	fallback.Args = nil
+	fallback.Argv = nil
+	fallback.Stdin = nil
+	fallback.Attempt = 0
+	fallback.Steps = nil
+	if noShell {
+		fallback.Argv, _ = splitCommandLine(onFailure)
+	}
+	// 主命令被停止时仍需完成处理命令
+	fallbackCtx := ctx
+	if ctx.Err() != nil {
+		fallbackCtx = context.WithoutCancel(ctx)
+	}
+	logWarn("命令执行结果为%s，执行失败处理命令 [ExecID:%s]", result.Status, execID)
+	onFailureResult := runCommand(fallbackCtx, execID, fallback)
+	result.OnFailure = &onFailureResult
+	return result
+}
+
+// 按顺序执行多条命令，遇到失败即停止，整体状态取第一条失败命令的状态
+func runSteps(ctx context.Context, execID string, opts ExecOptions) CommandResult {
+	startTime := time.Now()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	result := CommandResult{
+		ExecID:   execID,
+		Status:   "COMPLETED",
+		Command:  opts.Command,
+		ExecTime: startTime.Format(timeFormat),
+		Workdir:  opts.Workdir,
+		Env:      opts.EnvNames,
+		User:     runAs,
+		Priority: priorityDesc(),
+		Attempts: opts.Attempt,
+	}
+	var output, stdout, stderr strings.Builder
+	for i, step := range opts.Steps {
+		// 在两条命令之间被停止或超时时不再执行后续命令
+		if ctx.Err() != nil {
+			result.Status = "CANCELED"
+			result.Message = fmt.Sprintf("已通过exec_id %s停止执行", execID)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				result.Status = "TIMEOUT"
+				result.Message = fmt.Sprintf("执行超时（%s）", opts.Timeout)
+			}
+			result.Message = joinMessage(result.Message, fmt.Sprintf("第%d条命令未执行", i+1))
+			break
+		}
+		r := runCommand(ctx, execID, step)
+		result.Steps = append(result.Steps, StepResult{
+			Command:    r.Command,
+			Status:     r.Status,
+			ExitCode:   r.ExitCode,
+			ExecSecond: r.ExecSecond,
+			ExecMs:     r.ExecMs,
+			Output:     r.Output,
+			Error:      r.Error,
+		})
+		output.WriteString(r.Output)
+		stdout.WriteString(r.Stdout)
+		stderr.WriteString(r.Stderr)
+		result.ExitCode = r.ExitCode
+		result.Pid = r.Pid
+		result.OutputFile = r.OutputFile
+		result.Signal = r.Signal
+		if r.OutputTruncated {
+			result.OutputTruncated = true
+			result.OutputBytesTotal += r.OutputBytesTotal
+		}
+		if r.Status != "COMPLETED" {
+			result.Status = r.Status
+			result.Message = joinMessage(fmt.Sprintf("第%d条命令执行结果为%s", i+1, r.Status), r.Message)
+			result.Error = r.Error
+			result.Termination = r.Termination
+			break
+		}
+	}
+	result.Output = output.String()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.setTiming(startTime, time.Now())
+
+	logJSON(result)
+
+	return result
+}
+
+// 判断执行结果是否需要执行--on-failure命令
+func needsFailureHandling(status string) bool {
+	switch status {
+	case "FAILED", "START_FAILED", "KILLED_OOM", "KILLED_CPU":
+		return true
+	case "CANCELED", "TIMEOUT":
+		return onFailureAll
+	}
+	return false
+}
+
+func initOnFailure() error {
+	if onFailure == "" || !noShell {
+		return nil
+	}
+	if _, err := splitCommandLine(onFailure); err != nil {
+		return fmt.Errorf("--no-shell模式下--on-failure%v", err)
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, execID string, opts ExecOptions) CommandResult {
+	startTime := time.Now()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	// 每次执行时重新读取，文件中的密钥轮换后无需重启服务
+	fileEnv, err := loadEnvFile()
+	if err != nil {
+		result := CommandResult{
+			ExecID:   execID,
+			Status:   "START_FAILED",
+			Command:  opts.Command,
+			ExitCode: -1,
+			Workdir:  opts.Workdir,
+			Env:      opts.EnvNames,
+			Args:     opts.Args,
+			Attempts: opts.Attempt,
+			Error:    err.Error(),
+		}
+		result.setTiming(startTime, time.Now())
+		logJSON(result)
+		return result
+	}
+
+	cmd := buildCommand(ctx, opts)
+	cmd.Dir = opts.Workdir
+	if len(opts.Env) > 0 || len(fileEnv) > 0 || cleanEnv || childPath != "" {
+		cmd.Env = append(append(baseEnv(), fileEnv...), opts.Env...)
+	}
+	if opts.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(opts.Stdin)
+	}
+	setupProcessGroup(cmd)
+	applyRunAs(cmd)
+	terminator := newProcessTerminator(cmd, killGrace)
+
+	// stdout和stderr分别采集，同时按写入顺序合并到output中
+	stdout, stderr, combined := newOutputBuffer(), newOutputBuffer(), newOutputBuffer()
+	stdoutLines, stderrLines := newLineWriter(execID, "stdout"), newLineWriter(execID, "stderr")
+	cmd.Stdout = io.MultiWriter(stdout, combined, stdoutLines)
+	cmd.Stderr = io.MultiWriter(stderr, combined, stderrLines)
+	// 输出文件及流式输出按行隐藏敏感内容后写入
+	var redactWriters []*redactWriter
+	outputFile := openOutputFile(execID, startTime)
+	if outputFile != nil {
+		defer outputFile.Close()
+		fw := newRedactWriter(outputFile)
+		redactWriters = append(redactWriters, fw)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, fw)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, fw)
+	}
+	if opts.Output != nil {
+		ow := newRedactWriter(opts.Output)
+		redactWriters = append(redactWriters, ow)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, ow)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, ow)
+	}
+
+	updateExecution(execID, func(e *Execution) { e.Output = combined })
+
+	err = cmd.Start()
+	pid := 0
+	if err == nil {
+		pid = cmd.Process.Pid
+		updateExecution(execID, func(e *Execution) { e.Pid = pid })
+		afterStart(cmd)
+		err = cmd.Wait()
+		updateExecution(execID, func(e *Execution) { e.Pid = 0 })
+	}
+	terminator.stop()
+	stdoutLines.flush()
+	stderrLines.flush()
+	for _, rw := range redactWriters {
+		rw.flush()
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// 命令本身已成功退出，仅有后台子进程仍占用输出管道
+		err = nil
+	}
+	endTime := time.Now()
+
+	result := CommandResult{
+		ExecID:   execID,
+		Status:   "COMPLETED",
+		Command:  opts.Command,
+		Pid:      pid,
+		Output:   decodeOutput(combined.Bytes()),
+		Stdout:   decodeOutput(stdout.Bytes()),
+		Stderr:   decodeOutput(stderr.Bytes()),
+		Workdir:  opts.Workdir,
+		Env:      opts.EnvNames,
+		Args:     opts.Args,
+		User:     runAs,
+		Priority: priorityDesc(),
+		Attempts: opts.Attempt,
+	}
+	result.setTiming(startTime, endTime)
+	if outputFile != nil {
+		result.OutputFile = outputFile.path
+	}
+	if combined.Truncated() {
+		result.OutputTruncated = true
+		result.OutputBytesTotal = combined.Total()
+	}
+
+	if err != nil {
+		result.Status = "FAILED"
+		// 被信号终止或进程未能启动时退出码为-1
+		result.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+			// 经shell或nice等包装执行时，命令不存在或无执行权限体现为约定的退出码
+			wrapped := len(opts.Argv) == 0 || len(commandPrefix) > 0
+			if reason := startFailureReason(result.ExitCode); wrapped && reason != "" {
+				result.Status = "START_FAILED"
+				result.Error = reason
+				if msg := strings.TrimSpace(result.Stderr); msg != "" {
+					result.Error = msg
+				}
+			}
+		} else if cmd.Process == nil {
+			// 可执行文件不存在、无执行权限等原因导致进程未能启动
+			result.Status = "START_FAILED"
+			result.Error = err.Error()
+		}
+		result.Signal = terminationSignal(cmd.ProcessState)
+		if result.Signal == "" && len(opts.Argv) == 0 && result.ExitCode > 128 && result.ExitCode < 128+65 {
+			// 经shell执行时，命令被信号终止体现为shell的退出码128+信号值
+			result.Signal = signalName(result.ExitCode - 128)
+		}
+		if status := limitExceededStatus(cmd.ProcessState, result.ExitCode, result.Output); status != "" {
+			result.Status = status
+		}
+		// 通过stop/stopAll主动停止的执行不视为失败
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			result.Status = "CANCELED"
+			result.Message = fmt.Sprintf("已通过exec_id %s停止执行", execID)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			result.Status = "TIMEOUT"
+			result.Message = fmt.Sprintf("执行超时（%s）", opts.Timeout)
+		}
+		if ctx.Err() != nil {
+			result.Termination = "GRACEFUL"
+			if terminator.hardKilled() {
+				result.Termination = "KILLED"
+			}
+		}
+	}
+
+	logJSON(result)
+
+	return result
+}
+
+// 构造要执行的命令，存在argv时直接执行，参数原样传递不经过shell解释
+func buildCommand(ctx context.Context, opts ExecOptions) *exec.Cmd {
+	argv := commandArgv(opts)
+	if childPath != "" {
+		// exec按服务端的PATH查找程序，调整过PATH时需按子进程的PATH查找
+		argv[0] = lookPathIn(argv[0], childPath)
+	}
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// 生成最终执行的程序及参数
+func commandArgv(opts ExecOptions) []string {
+	argv := opts.Argv
+	if len(argv) == 0 {
+		cmdline := opts.Command
+		if shellWrap != nil {
+			cmdline = shellWrap(cmdline)
+		}
+		argv = append(append([]string{}, shellArgv...), cmdline)
+	}
+	if len(commandPrefix) > 0 {
+		argv = append(append([]string{}, commandPrefix...), argv...)
+	}
+	return argv
+}
+
+var (
+	// 包装命令的shell及其参数
+	shellArgv []string
+	// 交给shell执行前对命令的额外包装
+	shellWrap func(string) string
+	// 命令前附加的包装程序，如nice，包装程序需最终exec真正的命令
+	commandPrefix []string
+)
+
+// 校验执行优先级参数，按平台设置降低优先级的方式
+func initPriority() error {
+	if niceLevel < -20 || niceLevel > 19 {
+		return fmt.Errorf("--nice取值范围为-20~19: %d", niceLevel)
+	}
+	if ioniceClass < 0 || ioniceClass > 3 {
+		return fmt.Errorf("--ionice-class取值范围为1~3: %d", ioniceClass)
+	}
+	if priorityDesc() == "" {
+		return nil
+	}
+	if err := setupPriority(); err != nil {
+		return err
+	}
+	logInfo("命令执行优先级：%s", priorityDesc())
+	return nil
+}
+
+// 校验资源限制参数，仅linux下通过ulimit包装命令生效
+func initLimits() error {
+	if limitMem == 0 && limitCPU == 0 {
+		return nil
+	}
+	if limitCPU < 0 {
+		return fmt.Errorf("--limit-cpu-seconds不能为负数: %d", limitCPU)
+	}
+	if runtime.GOOS != "linux" {
+		logWarn("--limit-mem、--limit-cpu-seconds仅支持linux平台，当前平台下不生效")
+		limitMem, limitCPU = 0, 0
+		return nil
+	}
+	var ulimits []string
+	if limitMem > 0 {
+		// ulimit -v的单位为KB
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", max(int(limitMem>>10), 1)))
+	}
+	if limitCPU > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", limitCPU))
+	}
+	script := strings.Join(ulimits, " && ") + ` && exec "$@"`
+	commandPrefix = append(commandPrefix, "sh", "-c", script, "remotec-limit")
+	logInfo("命令资源限制：%s", strings.Join(ulimits, "，"))
+	return nil
+}
+
+func priorityDesc() string {
+	var parts []string
+	if niceLevel != 0 {
+		parts = append(parts, fmt.Sprintf("nice=%d", niceLevel))
+	}
+	if ioniceClass != 0 {
+		parts = append(parts, fmt.Sprintf("ionice=%d", ioniceClass))
+	}
+	return strings.Join(parts, ",")
+}
+
+// 解析--shell指定的shell，未指定时unix使用sh -c，windows使用cmd.exe /C
+func initShell() error {
+	if windowsShell != "" {
+		return initWindowsShell()
+	}
+	if shell == "" {
+		if runtime.GOOS == "windows" {
+			shellArgv = []string{"cmd.exe", "/C"}
+		} else {
+			shellArgv = []string{"sh", "-c"}
+		}
+		return nil
+	}
+	if noShell {
+		return errors.New("--shell与--no-shell不能同时使用")
+	}
+	argv, err := splitCommandLine(shell)
+	if err != nil {
+		return fmt.Errorf("无效的--shell: %v", err)
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return fmt.Errorf("指定的shell不存在: %s", argv[0])
+	}
+	shellArgv = argv
+	return nil
+}
+
+// 解析--windows-shell，powershell下强制UTF-8输出并透传脚本的退出码
+func initWindowsShell() error {
+	if runtime.GOOS != "windows" {
+		return errors.New("--windows-shell仅支持在windows下使用")
+	}
+	if shell != "" || noShell {
+		return errors.New("--windows-shell不能与--shell、--no-shell同时使用")
+	}
+	switch windowsShell {
+	case "cmd":
+		shellArgv = []string{"cmd.exe", "/C"}
+		return nil
+	case "powershell", "pwsh":
+		if _, err := exec.LookPath(windowsShell); err != nil {
+			return fmt.Errorf("指定的shell不存在: %s", windowsShell)
+		}
+		shellArgv = []string{windowsShell, "-NoProfile", "-NonInteractive", "-Command"}
+		shellWrap = func(cmdline string) string {
+			return "[Console]::OutputEncoding = [System.Text.Encoding]::UTF8; " + cmdline +
+				"; if (-not $?) { if ($LASTEXITCODE) { exit $LASTEXITCODE }; exit 1 }; exit $LASTEXITCODE"
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的--windows-shell: %s，可选值为cmd、powershell、pwsh", windowsShell)
+	}
+}
+
+// --no-shell模式下启动时即校验命令能否拆分为参数列表
+func initNoShell() error {
+	if !noShell {
+		return nil
+	}
+	for _, step := range commandSteps {
+		if _, err := splitCommandLine(step); err != nil {
+			return fmt.Errorf("--no-shell模式下%v", err)
+		}
+	}
+	return nil
+}
+
+// 按shell的引号规则将命令拆分为参数列表，不支持管道、重定向、变量等shell语法
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]) {
+				i++
+				current.WriteRune(runes[i])
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\':
+			if i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			}
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case strings.ContainsRune("|&;<>()$`", c):
+			return nil, fmt.Errorf("命令中包含不支持的shell语法 %q，如需管道、重定向等功能请使用shell执行", c)
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("命令中存在未闭合的引号")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("命令为空")
+	}
+	return args, nil
+}
+
+var (
+	// 命令输出的源编码，为nil时按UTF-8处理
+	outputEncoding encoding.Encoding
+	// 是否通过--output-encoding强制指定了编码
+	outputEncodingForced bool
 )
 
-type Execution struct {
-	ID      string
-	Cancel  context.CancelFunc
-	Stopped bool
+// windows常见控制台代码页对应的编码
+var codePageEncodings = map[uint32]encoding.Encoding{
+	437:   charmap.CodePage437,
+	866:   charmap.CodePage866,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1252:  charmap.Windows1252,
+	54936: simplifiedchinese.GB18030,
+}
+
+// 确定命令输出的编码，未指定时windows下根据控制台代码页识别
+func initOutputEncoding() error {
+	if outputCharset != "" {
+		enc, err := htmlindex.Get(outputCharset)
+		if err != nil {
+			return fmt.Errorf("不支持的输出编码: %s", outputCharset)
+		}
+		if enc != unicode.UTF8 {
+			outputEncoding = enc
+			outputEncodingForced = true
+		}
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		outputEncoding = codePageEncodings[consoleCodePage()]
+	}
+	return nil
+}
+
+// 将命令输出转换为UTF-8并隐藏敏感内容
+func decodeOutput(b []byte) string {
+	return redact(decodeBytes(b))
+}
+
+// 自动识别时已是合法UTF-8的输出（如powershell）不做转换
+func decodeBytes(b []byte) string {
+	if outputEncoding == nil || (!outputEncodingForced && utf8.Valid(b)) {
+		return string(b)
+	}
+	decoded, err := outputEncoding.NewDecoder().Bytes(b)
+	if err != nil {
+		return strings.ToValidUTF8(string(b), "\uFFFD")
+	}
+	return string(decoded)
+}
+
+// 根据shell约定的退出码判断命令是否未能启动
+func startFailureReason(exitCode int) string {
+	switch exitCode {
+	case 126:
+		return "命令无执行权限"
+	case 127:
+		return "命令不存在"
+	case 9009:
+		// cmd.exe找不到命令时的退出码
+		if runtime.GOOS == "windows" {
+			return "命令不存在"
+		}
+	}
+	return ""
+}
+
+// 进程终止控制：取消时先请求进程组退出，超过宽限期仍未退出则强制结束
+type processTerminator struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	killed bool
+}
+
+func newProcessTerminator(cmd *exec.Cmd, grace time.Duration) *processTerminator {
+	t := &processTerminator{}
+	cmd.Cancel = func() error {
+		pid := cmd.Process.Pid
+		if grace <= 0 {
+			t.markKilled()
+			return killProcessGroup(pid)
+		}
+		t.mu.Lock()
+		t.timer = time.AfterFunc(grace, func() {
+			// 持有锁直到记录完成，命令结束后stop()等待记录完成再判断是否被强制结束
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if killProcessGroup(pid) == nil {
+				logWarn("进程在%s宽限期内未退出，已强制结束 [PID:%d]", grace, pid)
+				t.killed = true
+			}
+		})
+		t.mu.Unlock()
+		return terminateProcessGroup(pid)
+	}
+	// 进程退出后如仍有子进程占用输出管道，最多再等待一个宽限期
+	cmd.WaitDelay = grace
+	return t
+}
+
+func (t *processTerminator) markKilled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.killed = true
+}
+
+func (t *processTerminator) hardKilled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.killed
+}
+
+// 命令结束后停止强制结束计时器
+func (t *processTerminator) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+func initTruncateMode() error {
+	if truncateMode != "tail" && truncateMode != "head" {
+		return fmt.Errorf("不支持的--truncate-mode: %s，可选值为tail、head", truncateMode)
+	}
+	return nil
+}
+
+// 有上限的并发安全输出缓冲区，stdout和stderr的采集协程会同时写入，
+// 超出上限后按--truncate-mode保留头部或尾部（环形缓冲）
+type limitedBuffer struct {
+	mu       sync.Mutex
+	limit    int
+	keepHead bool
+	buf      []byte
+	pos      int // 环形缓冲中最早数据的位置
+	wrapped  bool
+	total    int64
+}
+
+func newOutputBuffer() *limitedBuffer {
+	return &limitedBuffer{limit: int(maxOutput), keepHead: truncateMode == "head"}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	b.total += int64(n)
+
+	if b.limit <= 0 || len(b.buf)+len(p) <= b.limit && !b.wrapped {
+		b.buf = append(b.buf, p...)
+		return n, nil
+	}
+	if b.keepHead {
+		if room := b.limit - len(b.buf); room > 0 {
+			b.buf = append(b.buf, p[:room]...)
+		}
+		return n, nil
+	}
+	if !b.wrapped {
+		// 首次超出上限，填满缓冲区后转为环形写入
+		fill := b.limit - len(b.buf)
+		b.buf = append(b.buf, p[:fill]...)
+		p = p[fill:]
+		b.wrapped = true
+	}
+	if len(p) >= b.limit {
+		copy(b.buf, p[len(p)-b.limit:])
+		b.pos = 0
+		return n, nil
+	}
+	for len(p) > 0 {
+		c := copy(b.buf[b.pos:], p)
+		p = p[c:]
+		b.pos = (b.pos + c) % b.limit
+	}
+	return n, nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.wrapped {
+		return append([]byte(nil), b.buf...)
+	}
+	return append(append([]byte(nil), b.buf[b.pos:]...), b.buf[:b.pos]...)
 }
 
-var (
-	execLock   sync.Mutex
-	executions = make(map[string]*Execution)
-)
+func (b *limitedBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit > 0 && b.total > int64(b.limit)
+}
 
-type CommandResult struct {
-	ExecID     string  `json:"exec_id"`
-	Status     string  `json:"status"`
-	Command    string  `json:"command"`
-	Message    string  `json:"message"`
-	ExecTime   string  `json:"exec_time"`
-	ExecSecond float64 `json:"exec_second"`
-	Output     string  `json:"output"`
+func (b *limitedBuffer) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
 }
 
-// POST请求参数结构体
-type RequestParams struct {
-	Action string `json:"action"`
-	Delay  int    `json:"delay"`
-	Count  int    `json:"count"`
-	ExecID string `json:"exec_id"`
+func initOutputDir() error {
+	if outputDir == "" {
+		if outputKeep > 0 {
+			logWarn("--output-keep仅在--output-dir时生效")
+		}
+		return nil
+	}
+	if outputKeep < 0 {
+		return fmt.Errorf("--output-keep不能为负数: %d", outputKeep)
+	}
+	if !strings.Contains(outputName, "{exec_id}") && !strings.Contains(outputName, "{timestamp}") {
+		return errors.New("--output-name中至少需要包含{exec_id}或{timestamp}")
+	}
+	if strings.ContainsAny(outputName, `/\`) {
+		return fmt.Errorf("--output-name不能包含路径分隔符: %s", outputName)
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("创建输出目录失败: %v", err)
+	}
+	logInfo("命令输出写入目录：%s", outputDir)
+	return nil
 }
 
-func init() {
-	flag.StringVar(&port, "p", "", "监听的端口号")
-	flag.StringVar(&command, "c", "", "要执行的命令")
-	flag.StringVar(&token, "token", "", "认证token")
-	flag.StringVar(&endpoint, "endpoint", "", "自定义端点路径")
-	flag.BoolVar(&showVersion, "v", false, "显示版本号")
-	flag.BoolVar(&showHelp, "help", false, "显示帮助信息")
+// 写入输出文件，写入失败只记录一次日志，不影响命令执行
+type outputFile struct {
+	*os.File
+	path   string
+	mu     sync.Mutex // stdout和stderr的采集协程会同时写入
+	failed bool
 }
 
-func main() {
-	initAppConfig()
-	flag.Parse()
-	setupLogger()
+func (f *outputFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failed {
+		if _, err := f.File.Write(p); err != nil {
+			f.failed = true
+			logWarn("写入输出文件失败: %v", err)
+		}
+	}
+	return len(p), nil
+}
 
-	if showVersion {
-		fmt.Println(appConfig.Version)
-		return
+func (f *outputFile) Close() error {
+	err := f.File.Close()
+	pruneOutputFiles()
+	return err
+}
+
+// 创建本次执行的输出文件，失败时返回nil
+func openOutputFile(execID string, startTime time.Time) *outputFile {
+	if outputDir == "" {
+		return nil
+	}
+	name := strings.NewReplacer(
+		"{exec_id}", execID,
+		"{timestamp}", startTime.Format("20060102150405.000"),
+	).Replace(outputName)
+	path := filepath.Join(outputDir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		logWarn("创建输出文件失败: %v", err)
+		return nil
 	}
+	return &outputFile{File: file, path: path}
+}
 
-	if showHelp || len(os.Args) == 1 {
-		printHelp()
+var pruneLock sync.Mutex
+
+// 按--output-keep删除输出目录中最旧的文件
+func pruneOutputFiles() {
+	if outputKeep <= 0 {
 		return
 	}
+	pruneLock.Lock()
+	defer pruneLock.Unlock()
 
-	if port == "" || command == "" {
-		logError("必须提供端口号(-p)和命令(-c)")
-		os.Exit(1)
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		logWarn("读取输出目录失败: %v", err)
+		return
+	}
+	type fileInfo struct {
+		path    string
+		modTime time.Time
+	}
+	var files []fileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, fileInfo{filepath.Join(outputDir, entry.Name()), info.ModTime()})
+	}
+	if len(files) <= outputKeep {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files[:len(files)-outputKeep] {
+		if err := os.Remove(file.path); err != nil {
+			logWarn("删除输出文件失败: %v", err)
+		}
 	}
-
-	startServer()
 }
 
-func initAppConfig() {
-	if len(embeddedConfig) == 0 {
-		appConfig.Version = "unknown" // 默认版本号
+func sendResponse(w http.ResponseWriter, data interface{}, code int) {
+	data = withIdentity(data)
+	if isTextResponse(w) && sendText(w, data, code) {
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
 
-	if err := yaml.Unmarshal(embeddedConfig, &appConfig); err != nil {
-		logWarn("解析配置文件失败: %v", err)
-		appConfig.Version = "unknown" // 解析失败时设置默认版本号
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(data); err != nil {
+		logError("响应编码失败: %v", err)
 	}
 }
 
-func startServer() {
-	endpointPath := getEndpoint()
-	url := fmt.Sprintf("http://localhost:%s/%s", port, endpointPath)
+// 纯文本响应模式，单次、多次执行只返回命令输出，状态通过HTTP状态码及X-Remotec-*响应头返回
+type textResponseWriter struct {
+	http.ResponseWriter
+}
 
-	handler := http.HandlerFunc(requestHandler)
-	if token != "" {
-		handler = tokenAuthMiddleware(handler)
-	}
+func (t *textResponseWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
 
-	http.HandleFunc("/"+endpointPath, handler)
-	logInfo("服务启动成功，监听地址：%s", url)
-	if token != "" {
-		logInfo("token已设置，接口调用时需传递请求头：'token: %s'", token)
+func (t *textResponseWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
+}
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		logError("服务器启动失败: %v", err)
-		os.Exit(1)
+// 请求指定format=text或Accept优先接受text/plain时使用纯文本响应
+func wantsText(r *http.Request, params RequestParams) bool {
+	if params.Format != "" {
+		return params.Format == "text"
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/plain":
+			return true
+		case "application/json":
+			return false
+		}
 	}
+	return false
 }
 
-func tokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		reqToken := r.Header.Get("token")
-		if token != "" && reqToken != token {
-			logWarn("认证失败，未收到正确的token")
-			sendError(w, "未授权", http.StatusForbidden)
-			return
+func isTextResponse(w http.ResponseWriter) bool {
+	for {
+		switch v := w.(type) {
+		case *textResponseWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return false
 		}
-		next(w, r)
 	}
 }
 
-func requestHandler(w http.ResponseWriter, r *http.Request) {
-	// 支持GET和POST方法
-	if r.Method != http.MethodGet && r.Method != http.MethodPost {
-		sendError(w, "方法不允许", http.StatusMethodNotAllowed)
-		return
+// 以纯文本写入执行结果或错误，其他响应返回false，仍使用JSON
+func sendText(w http.ResponseWriter, data interface{}, code int) bool {
+	var body string
+	switch v := data.(type) {
+	case CommandResult:
+		h := w.Header()
+		h.Set("X-Remotec-Exec-Id", v.ExecID)
+		h.Set("X-Remotec-Status", v.Status)
+		h.Set("X-Remotec-Exit-Code", strconv.Itoa(v.ExitCode))
+		h.Set("X-Remotec-Duration-Ms", strconv.FormatInt(v.ExecMs, 10))
+		if v.Hostname != "" {
+			h.Set("X-Remotec-Hostname", v.Hostname)
+		}
+		// 执行结果不是COMPLETED时以500表示失败
+		if code == http.StatusOK && v.Status != "COMPLETED" {
+			code = http.StatusInternalServerError
+		}
+		body = v.Output
+		if body == "" && v.Error != "" {
+			body = v.Error + "\n"
+		}
+	case map[string]string:
+		msg, ok := v["error"]
+		if !ok {
+			return false
+		}
+		body = "错误: " + msg + "\n"
+	default:
+		return false
 	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	io.WriteString(w, body)
+	return true
+}
 
-	var params RequestParams
-	var err error
+// 启动时确定本机的主机名，供集中收集日志时区分来源
+func initIdentity() error {
+	name, err := os.Hostname()
+	if err != nil {
+		logWarn("获取主机名失败: %v", err)
+	}
+	hostname = name
+	return nil
+}
 
-	// 解析请求参数
-	if r.Method == http.MethodGet {
-		// 从查询参数解析
-		params.Action = r.URL.Query().Get("action")
-		params.Delay, _ = strconv.Atoi(r.URL.Query().Get("delay"))
-		params.Count, _ = strconv.Atoi(r.URL.Query().Get("count"))
-		params.ExecID = r.URL.Query().Get("exec_id")
-	} else {
-		// 从JSON body解析
-		defer r.Body.Close()
-		if err = json.NewDecoder(r.Body).Decode(&params); err != nil {
-			sendError(w, "无效的JSON格式", http.StatusBadRequest)
-			return
+// 在执行结果及错误响应中附加主机名和实例名称
+func withIdentity(data interface{}) interface{} {
+	switch v := data.(type) {
+	case CommandResult:
+		v.Hostname, v.Instance = hostname, instanceName
+		if v.Tags == nil && v.ExecID != "" {
+			v.Tags = lookupTags(v.ExecID)
 		}
-		// 处理可能缺失的字段（默认值处理）
-		if params.Action == "" {
-			params.Action = "single" // 默认行为，类似原逻辑
+		return v
+	case map[string]string:
+		if hostname != "" {
+			v["hostname"] = hostname
+		}
+		if instanceName != "" {
+			v["instance"] = instanceName
 		}
 	}
+	return data
+}
 
-	switch params.Action {
-	case "multiple":
-		handleMultiple(w, r, params)
-	case "loop":
-		handleLoop(w, r, params)
-	case "stop":
-		handleStop(w, r, params)
-	case "stopAll":
-		handleStopAll(w, r)
-	default:
-		handleSingle(w, r, params)
-	}
+func sendError(w http.ResponseWriter, msg string, code int) {
+	sendResponse(w, map[string]string{"error": msg}, code)
 }
 
-func handleStopAll(w http.ResponseWriter, r *http.Request) {
+// 注册执行，exec_id已被正在进行的执行使用时返回false
+func registerExecution(id, action, command string, params RequestParams, cancel context.CancelFunc) bool {
 	execLock.Lock()
 	defer execLock.Unlock()
+	if _, exists := executions[id]; exists {
+		return false
+	}
+	// 与已结束的执行重名时替换旧的记录
+	if _, exists := finishedExecutions[id]; exists {
+		delete(finishedExecutions, id)
+		finishedOrder = slices.DeleteFunc(finishedOrder, func(s string) bool { return s == id })
+	}
+	executions[id] = &Execution{
+		ID:        id,
+		Action:    action,
+		Command:   command,
+		StartTime: time.Now(),
+		Cancel:    cancel,
+		Done:      make(chan struct{}),
+		Wake:      make(chan struct{}, 1),
+		Trigger:   make(chan chan CommandResult, 1),
+		Tags:      params.Tags,
 
-	stoppedCount := 0
-	for id, execution := range executions {
-		execution.Cancel()
-		execution.Stopped = true
-		stoppedCount++
-		logInfo("已停止执行 [ExecID:%s]", id)
+		CallbackURL:            params.CallbackURL,
+		CallbackEveryIteration: params.CallbackEveryIteration,
 	}
+	setExecutionTags(id, params.Tags)
+	return true
+}
 
-	executions = make(map[string]*Execution)
+// 执行的标签，单独加锁保存，发送响应及输出日志时附加到结果中
+var (
+	tagsLock      sync.Mutex
+	executionTags = make(map[string][]string)
+)
 
-	sendResponse(w, CommandResult{
-		Status:  "STOPPED_ALL",
-		Message: fmt.Sprintf("已停止%d个正在执行的任务", stoppedCount),
-	}, http.StatusOK)
+func setExecutionTags(id string, tags []string) {
+	tagsLock.Lock()
+	defer tagsLock.Unlock()
+	if len(tags) == 0 {
+		delete(executionTags, id)
+		return
+	}
+	executionTags[id] = tags
 }
 
-func handleLoop(w http.ResponseWriter, r *http.Request, params RequestParams) {
-	delay := params.Delay
-	execID := generateID()
-	ctx, cancel := context.WithCancel(context.Background())
+func lookupTags(id string) []string {
+	tagsLock.Lock()
+	defer tagsLock.Unlock()
+	return executionTags[id]
+}
 
-	registerExecution(execID, cancel)
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:=-]{1,64}$`)
 
-	go func() {
-		defer cleanExecution(execID)
+const maxTags = 10
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				executeCommand(ctx, execID)
-				if delay > 0 {
-					time.Sleep(time.Duration(delay) * time.Second)
-				}
-			}
+// 校验标签，限制数量及长度
+func validateTags(tags []string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("标签不能超过%d个", maxTags)
+	}
+	for _, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("无效的标签: %q，只能包含字母、数字及_.:=-，长度1~64", tag)
 		}
-	}()
-
-	sendResponse(w, CommandResult{
-		ExecID:   execID,
-		Status:   "STARTED",
-		Command:  command,
-		Message:  fmt.Sprintf("循环执行，间隔：%d秒", delay),
-		ExecTime: time.Now().Format(timeFormat),
-	}, http.StatusOK)
+	}
+	return nil
 }
 
-func handleMultiple(w http.ResponseWriter, r *http.Request, params RequestParams) {
-	count := max(params.Count, 1)
-	delay := params.Delay
-	execID := generateID()
-	ctx, cancel := context.WithCancel(context.Background())
+func hasTag(execution *Execution, tag string) bool {
+	return slices.Contains(execution.Tags, tag)
+}
 
-	registerExecution(execID, cancel)
-	defer cleanExecution(execID)
+// 请求指定了exec_id时使用该ID，便于客户端重试时不重复执行
+func requestExecID(params RequestParams) string {
+	if params.ExecID != "" {
+		return params.ExecID
+	}
+	return generateID()
+}
 
-	startTime := time.Now()
+// 请求指定的exec_id已在执行中时返回该执行的信息，不再重复执行
+func sendAlreadyRunning(w http.ResponseWriter, id string) {
+	execLock.Lock()
+	execution, exists := executions[id]
 	var result CommandResult
-
-	for i := 0; i < count; i++ {
-		select {
-		case <-ctx.Done():
-			logInfo("多次执行已停止 [ExecID:%s]", execID)
-			return
-		default:
-			result = executeCommand(ctx, execID)
-			if delay > 0 && i < count-1 {
-				time.Sleep(time.Duration(delay) * time.Second)
-			}
+	if exists {
+		result = CommandResult{
+			ExecID:   id,
+			Status:   "ALREADY_RUNNING",
+			Command:  execution.Command,
+			Message:  fmt.Sprintf("exec_id为%s的%s执行正在进行中，未重复执行", id, execution.Action),
+			ExecTime: execution.StartTime.Format(timeFormat),
+			Pid:      execution.Pid,
 		}
 	}
-
-	sendResponse(w, CommandResult{
-		ExecID:     execID,
-		Status:     "COMPLETED",
-		Command:    command,
-		Message:    fmt.Sprintf("多次执行，次数：%d，间隔：%d秒", count, delay),
-		ExecTime:   time.Now().Format(timeFormat),
-		ExecSecond: time.Since(startTime).Seconds(),
-		Output:     result.Output,
-	}, http.StatusOK)
-}
-
-func handleStop(w http.ResponseWriter, r *http.Request, params RequestParams) {
-	execID := params.ExecID
-	if execID == "" {
-		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+	execLock.Unlock()
+	if !exists {
+		sendError(w, "exec_id正在被使用，请稍后重试", http.StatusConflict)
 		return
 	}
+	logInfo("%s", result.Message)
+	sendResponse(w, result, http.StatusOK)
+}
 
+// 在锁内更新执行记录，已被停止的执行仍会记录最后一次执行的结果
+func updateExecution(id string, update func(*Execution)) {
 	execLock.Lock()
 	defer execLock.Unlock()
-
-	if execution, exists := executions[execID]; exists {
-		execution.Cancel()
-		execution.Stopped = true
-		delete(executions, execID)
-		logInfo("已停止执行 [ExecID:%s]", execID)
-		sendResponse(w, CommandResult{ExecID: execID, Status: "STOPPED"}, http.StatusOK)
-	} else {
-		sendError(w, "无效的exec_id", http.StatusNotFound)
+	if execution, exists := executions[id]; exists {
+		update(execution)
+	} else if execution, exists := finishedExecutions[id]; exists {
+		update(execution)
 	}
 }
 
-func handleSingle(w http.ResponseWriter, r *http.Request, params RequestParams) {
-	startTime := time.Now()
-	execID := generateID()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// 记录一次执行完成后的结果
+func recordIteration(id string, result CommandResult) {
+	action := ""
+	defer func() { addHistory(action, result) }()
+	updateExecution(id, func(e *Execution) {
+		action = e.Action
+		// 被停止的那次执行不计入完成次数
+		if result.Status != "CANCELED" && result.Status != "EXPIRED" {
+			e.Iterations++
+			if result.Status != "COMPLETED" {
+				e.Failed++
+				e.ConsecutiveFailures++
+			} else {
+				e.ConsecutiveFailures = 0
+			}
+		}
+		e.Last = &result
+		publishLocked(e, result)
+		if e.CallbackEveryIteration && e.Action != "single" {
+			enqueueCallback(e.CallbackURL, result)
+		}
+		if e.Running > 0 {
+			e.Running--
+		}
+		if e.Running == 0 {
+			e.IterationStartedAt = time.Time{}
+		}
+		if e.Action == "loop" {
+			e.Recent = append(e.Recent, result)
+			if len(e.Recent) > recentResultsKeep {
+				e.Recent = e.Recent[len(e.Recent)-recentResultsKeep:]
+			}
+		}
+	})
+}
 
-	registerExecution(execID, cancel)
-	result := executeCommand(ctx, execID)
-	cleanExecution(execID)
-	duration := time.Since(startTime).Seconds()
+func (e *Execution) kind() string {
+	if e.Kind == "" {
+		return "running"
+	}
+	return e.Kind
+}
 
-	sendResponse(w, CommandResult{
-		ExecID:     execID,
-		Status:     "COMPLETED",
-		Command:    command,
-		Message:    "单次执行",
-		ExecTime:   startTime.Format(timeFormat),
-		ExecSecond: duration,
-		Output:     result.Output,
-	}, http.StatusOK)
+// 定时执行尚未开始
+func (e *Execution) pending() bool {
+	return !e.ScheduledAt.IsZero() && e.Iterations == 0 && e.Running == 0
 }
 
-func executeCommand(ctx context.Context, execID string) CommandResult {
-	startTime := time.Now()
-	var cmd *exec.Cmd
+// 记录当前这次执行的开始时间
+func startIteration(id string) {
+	updateExecution(id, func(e *Execution) {
+		e.IterationStartedAt = time.Now()
+		e.Running++
+	})
+}
 
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", command)
+func cleanExecution(id string) {
+	execLock.Lock()
+	defer execLock.Unlock()
+	execution, exists := executions[id]
+	if exists {
+		finishExecutionLocked(execution)
 	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
-	}
-
-	output, err := cmd.CombinedOutput()
-	duration := time.Since(startTime).Seconds()
-
-	result := CommandResult{
-		ExecID:     execID,
-		Status:     "COMPLETED",
-		Command:    command,
-		ExecTime:   startTime.Format(timeFormat),
-		ExecSecond: duration,
-		Output:     string(output),
+		// 已通过stop移入已结束列表
+		execution, exists = finishedExecutions[id]
 	}
-
-	if err != nil {
-		result.Status = "FAILED"
+	if exists && execution.Done != nil {
+		select {
+		case <-execution.Done:
+		default:
+			close(execution.Done)
+			finalCallback(execution)
+		}
 	}
-
-	logJSON(result)
-
-	return result
 }
 
-func sendResponse(w http.ResponseWriter, data interface{}, code int) {
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(code)
-
-	enc := json.NewEncoder(w)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", "  ")
-
-	if err := enc.Encode(data); err != nil {
-		logError("响应编码失败: %v", err)
+// 执行结束时回调汇总结果，没有汇总结果时回调最后一次执行的结果，调用方需持有execLock
+func finalCallback(execution *Execution) {
+	switch {
+	case execution.CallbackURL == "":
+	case execution.Result != nil:
+		enqueueCallback(execution.CallbackURL, *execution.Result)
+	case execution.Last != nil && !(execution.CallbackEveryIteration && execution.Action != "single"):
+		// 每次执行都已回调时不再重复发送
+		enqueueCallback(execution.CallbackURL, *execution.Last)
 	}
 }
 
-func sendError(w http.ResponseWriter, msg string, code int) {
-	sendResponse(w, map[string]string{"error": msg}, code)
-}
-
-func registerExecution(id string, cancel context.CancelFunc) {
-	execLock.Lock()
-	defer execLock.Unlock()
-	executions[id] = &Execution{ID: id, Cancel: cancel}
+// 将执行移入已结束列表并清理过期的记录，调用方需持有execLock
+func finishExecutionLocked(execution *Execution) {
+	delete(executions, execution.ID)
+	execution.FinishedAt = time.Now()
+	finishedExecutions[execution.ID] = execution
+	if execution.Action == "loop" && !execution.Interrupted {
+		removeExecution(execution.ID)
+	}
+	finishedOrder = append(finishedOrder, execution.ID)
+	for len(finishedOrder) > max(maxResults, 0) {
+		setExecutionTags(finishedOrder[0], nil)
+		delete(finishedExecutions, finishedOrder[0])
+		finishedOrder = finishedOrder[1:]
+	}
 }
 
-func cleanExecution(id string) {
-	execLock.Lock()
-	defer execLock.Unlock()
-	delete(executions, id)
-}
+var execIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
 
 func generateID() string {
 	b := make([]byte, 8)
@@ -404,6 +4350,7 @@ func setupLogger() {
 }
 
 func logJSON(data interface{}) {
+	data = withIdentity(data)
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 	enc.SetEscapeHTML(false)
@@ -412,6 +4359,12 @@ func logJSON(data interface{}) {
 	}
 }
 
+func logDebug(format string, v ...interface{}) {
+	if debug {
+		logMessage("DEBUG", format, v...)
+	}
+}
+
 func logInfo(format string, v ...interface{}) {
 	logMessage("INFO", format, v...)
 }
@@ -444,29 +4397,199 @@ func printHelp() {
   remotec -p 端口号 -c 命令 [选项]
 
 选项列表：
-  -p            string    监听的端口号 (必填)
-  -c            string    要执行的系统命令 (必填)
-  --token       string    认证token (选填)
-  --endpoint    string    自定义端点路径 (选填)
-  -v                      显示版本号
-  --help                  显示帮助信息
+  -p                    string    监听的端口号 (必填)
+  -c                    string    要执行的系统命令，与--script二选一；重复指定时按顺序执行，遇到失败即停止 (必填)
+  --script              string    要执行的脚本文件，按shell执行（sh、cmd /C或powershell -File），修改后下次执行即生效 (选填)
+  --token               string    认证token，可重复指定或以逗号分隔，任一token均可通过认证，
+                                  可写作label:value，日志中只显示label及token的首尾字符；
+                                  可写作label:value:过期时间（如2025-01-31T00:00:00Z，不含时区时按服务时区（UTC+8）解析），过期后拒绝认证 (选填)
+  --token-env           string    从该环境变量读取token（读取后从环境中移除，命令不会继承），未指定--token时使用 (选填)
+  --token-file          string    从该文件读取token，每行一个，未指定--token、--token-env时使用 (选填)
+  --role                string    限制token可执行的action，格式为label=action1,action2，label为--token的标签，
+                                  如readonly=list,status,history,stats，未指定--role的token可执行全部action (选填)
+  --signing-secret      string    请求签名的密钥，设置后每个请求须携带HMAC-SHA256签名，可与--token同时使用，签名方式见下方说明 (选填)
+  --allow-ip            string    允许访问的IP或CIDR（IPv4、IPv6），可重复指定或以逗号分隔，其他地址的请求（包括健康检查）返回403 (选填)
+  --trusted-proxy       string    信任的反向代理IP或CIDR，来自这些地址的请求按X-Forwarded-For（最右侧的非代理地址）
+                                  或X-Real-IP识别客户端IP，可重复指定或以逗号分隔 (选填)
+  --rate-limit          float     每个客户端IP每秒允许的请求数（可为小数，如0.5），超出时返回429及Retry-After响应头，
+                                  IPv6客户端按/64网段计数，健康检查及版本号接口不受限制，默认不限制 (选填)
+  --rate-burst          int       允许的突发请求数，默认为--rate-limit向上取整 (选填)
+  --audit-log           string    审计日志文件，以JSON Lines追加记录每个接口请求（包括认证失败的请求）：时间、客户端IP、
+                                  token标签、action、参数（env的值及stdin以***代替）、exec_id及响应状态码 (选填)
+  --tls-cert            string    PEM格式的TLS证书文件，与--tls-key同时指定时以HTTPS提供服务，证书文件更新后自动重新加载 (选填)
+  --tls-key             string    PEM格式的TLS私钥文件，须与--tls-cert同时指定 (选填)
+  --tls-self-signed               启动时生成ECDSA自签名证书并以HTTPS提供服务，SAN包含主机名、localhost及本机IP，
+                                  设置--data-dir时证书保存在数据目录中，重启后不变；启动日志中输出证书的SHA-256指纹，
+                                  不能与--tls-cert、--tls-key同时使用 (选填)
+  --acme-domain         string    通过ACME（Let's Encrypt）自动申请及续期证书的域名，可重复指定或以逗号分隔，
+                                  需监听80端口完成HTTP-01验证，-p通常为443，不能与--tls-cert、--tls-self-signed同时使用 (选填)
+  --acme-cache-dir      string    ACME账号密钥及证书的保存目录，默认为--data-dir下的acme目录 (选填)
+  --allow-actions       string    服务端启用的action，逗号分隔，如single,stop，其他action（包括默认的single）返回403，
+                                  默认全部启用，启用的action可通过action=info查看 (选填)
+  --read-only                     只读模式，只允许list、schedules、stream、attach、wait、info、stats、validate、status、result、
+                                  history、export，执行、停止等其他action及stats的reset=true返回403，与--allow-actions同时使用时取交集 (选填)
+  --endpoint            string    自定义端点路径 (选填)
+  --kill-grace          duration  停止执行时等待进程退出的宽限期，超时后强制结束，默认10s (选填)
+  --allow-workdir       string    允许请求指定的工作目录，可重复指定 (选填)
+  --allow-env           string    允许请求注入的环境变量名，逗号分隔或重复指定 (选填)
+  --allow-args                    允许请求通过args追加命令参数 (选填)
+  --param               string    命令模板参数及校验正则，格式 name=regex，命令中以{name}引用，可重复指定 (选填)
+  --no-shell                      不经过sh -c或cmd /C，按引号规则拆分命令后直接执行 (选填)
+  --shell               string    执行命令使用的shell，如 "bash -c"、"busybox sh -c"，默认sh -c或cmd /C (选填)
+  --windows-shell       string    windows下使用的shell：cmd、powershell、pwsh，默认cmd (选填)
+  --output-encoding     string    命令输出的源编码，如gbk、big5，默认windows下按控制台代码页自动转换为UTF-8 (选填)
+  --run-as              string    以指定用户执行命令，格式 user[:group]，需以root运行，不支持windows (选填)
+  --nice                int       执行命令的nice值，取值-20~19，windows下映射为进程优先级类别 (选填)
+  --ionice-class        int       执行命令的IO调度类别：1实时、2尽力而为、3空闲，仅linux (选填)
+  --limit-mem           string    执行命令的虚拟内存上限，如512M，超出时status为KILLED_OOM，仅linux (选填)
+  --limit-cpu-seconds   int       执行命令的CPU时间上限（秒），超出时status为KILLED_CPU，仅linux (选填)
+  --max-output-bytes    string    单次执行保留的最大输出，如1M，默认1M，0表示不限制 (选填)
+  --redact              string    命令输出中需隐藏的内容的正则，可重复指定，匹配的内容在响应、日志、执行历史、输出文件中替换为[REDACTED]；
+                                  完整输出按整段匹配，可使用(?s)跨行匹配，存在跨行的规则时不支持流式输出；8个字符以上的token始终会被隐藏 (选填)
+  --max-body-bytes      string    请求内容的最大字节数，如512K，超出时返回413，默认1M，0表示不限制 (选填)
+  --truncate-mode       string    输出超出上限时的截断方式：tail保留尾部、head保留头部，默认tail (选填)
+  --on-failure          string    命令执行失败后执行的处理命令，结果附加在on_failure中 (选填)
+  --on-failure-always             命令被停止或超时时也执行--on-failure命令 (选填)
+  --dry-run                       所有请求均为试运行，只返回将要执行的命令详情而不实际执行 (选填)
+  --check                         按实际配置执行一次命令并输出结果后退出，成功返回0，不启动服务 (选填)
+  --check-timeout       duration  --check执行命令的超时时间，默认30s (选填)
+  --max-exec-time       duration  单次命令执行的最长时间，超时status为TIMEOUT，请求的timeout不能超过该值 (选填)
+  --clean-env                     命令不继承服务端的环境变量，仅保留PATH、HOME、LANG等最小集合 (选填)
+  --keep-env            string    --clean-env时额外保留的环境变量名，逗号分隔或重复指定 (选填)
+  --path                string    替换命令可见的PATH (选填)
+  --path-prepend        string    添加到命令可见PATH之前的目录，可重复指定 (选填)
+  --env-file            string    环境变量文件，每行KEY=VALUE，每次执行时重新读取，文件不可读时status为START_FAILED (选填)
+  --output-dir          string    将每次执行的输出写入该目录下的文件，结果中的output_file为文件路径，写入失败不影响命令执行 (选填)
+  --output-name         string    输出文件名模板，支持{exec_id}、{timestamp}，默认{exec_id}_{timestamp}.log (选填)
+  --output-keep         int       输出目录中最多保留的文件数，超出时删除最旧的文件，默认0不清理 (选填)
+  --instance-name       string    实例名称，与主机名一起包含在每个执行结果及错误响应中 (选填)
+  --max-results         int       保留状态及结果的已结束执行数，超出时淘汰最早结束的，默认100 (选填)
+  --history-size        int       执行历史保留的条数，通过action=history查询，默认100 (选填)
+  --data-dir            string    数据目录，持久化执行历史及正在运行的循环执行，重启后可查询历史及被中断的循环执行 (选填)
+  --data-retention      duration  数据目录中执行历史的保留时间，默认720h (选填)
+  --idempotency-ttl     duration  单次执行请求携带Idempotency-Key请求头时缓存结果的时间，重复的请求直接返回缓存的结果，默认24h (选填)
+  --idempotency-keys    int       最多缓存的Idempotency-Key数量，超出时淘汰最早的已完成记录，均在执行中时返回503，默认1000 (选填)
+  --idempotency-reject            相同Idempotency-Key的请求正在执行时返回409，默认等待其执行结束后返回相同结果 (选填)
+  --callback-allow      string    允许请求callback_url使用的主机，如 hooks.example.com、*.example.com、host:8443，可重复指定 (选填)
+  --debug                         输出调试日志，如循环执行每次间隔的抖动 (选填)
+  --rest                          在端点路径下同时提供RESTful接口/v1/executions，见下方RESTful接口说明 (选填)
+  --health                        提供不需要token的健康检查接口（返回status、version、uptime_seconds）及就绪检查接口，
+                                  就绪检查确认可执行文件、脚本、工作目录可用，任一不可用时返回503 (选填)
+  --health-path         string    健康检查接口的路径，默认/healthz (选填)
+  --ready-path          string    就绪检查接口的路径，默认/readyz (选填)
+  --readiness-exec      duration  就绪检查时试运行命令（超时10秒），每个间隔内最多一次，结果失败时返回503，不能与--param同时使用，默认0不试运行 (选填)
+  --version-path        string    提供不需要token的版本号接口，返回version、commit、build_date，如/version，默认不提供 (选填)
+  --allow-shutdown                允许通过action=shutdown&confirm=true远程关闭服务：停止全部执行后优雅退出，进程退出码为0 (选填)
+  -v                              显示版本号
+  --help                          显示帮助信息
 
 程序启动示例：
   remotec -p 8080 -c "ping 127.0.0.1 -c 2" --token your_token
+  remotec -p 8080 -c "make deploy" --token team-a:token_a --token team-b:token_b
+  remotec -p 8080 -c "make deploy" --token deploy:token_a:2025-01-31T00:00:00Z
+  remotec -p 8080 -c "make deploy" --token admin:token_a --token readonly:token_b --role readonly=list,status,history,stats
+  REMOTEC_TOKEN=your_token remotec -p 8080 -c "make deploy" --token-env REMOTEC_TOKEN
+  remotec -p 8080 -c "make deploy" --token your_token --allow-ip 10.0.0.5,10.0.1.0/24 --allow-ip fd00::/8
+  remotec -p 8080 -c "make deploy" --token your_token --rate-limit 1 --rate-burst 5
+  remotec -p 8080 -c "./deploy.sh" --redact 'password=\S+' --redact '(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----'
+  remotec -p 8443 -c "make deploy" --token your_token --tls-cert server.crt --tls-key server.key
+  remotec -p 8443 -c "make deploy" --token your_token --tls-self-signed --data-dir /var/lib/remotec
+  remotec -p 443 -c "make deploy" --token your_token --acme-domain remotec.example.com --data-dir /var/lib/remotec
+  remotec -p 8080 -c "ping -c 3 {host}" --param 'host=[a-zA-Z0-9.-]+'
+  remotec -c "systemctl is-active nginx" --check
+  remotec -p 8080 -c "git pull" -c "make build" -c "systemctl restart app"
 
 接口请求参数：
-  action      string    执行动作（multiple、loop、stop、stopAll）
-  delay       int       循环执行间隔（秒）
-  count       int       多次执行次数
-  exec_id     string    执行ID（请求返回中获得）
+  action                string    执行动作（multiple、loop、at、stop、stopAll、list、schedules、stream、attach、wait、info、stats、validate、shutdown、rotate-token、mint-token、status、result、history、export、pause、resume、update、trigger）
+  delay                 duration  多次、循环执行的间隔，秒数或时长字符串（如500ms、2m30s），action=update时为新的间隔
+  count                 int       多次执行次数
+  max_iterations        int       循环执行的最大次数，达到后结束并记录汇总结果（总次数、失败次数、总耗时），默认0不限制
+  max_duration          duration  循环执行的最长运行时间，秒数或时长字符串（如2h），到期后结束并记录status为EXPIRED的汇总结果
+  fail_threshold        int       循环执行连续失败达到该次数时自动停止，记录status为STOPPED_ON_FAILURES的汇总结果，成功一次后重新计数
+  until_match           string    循环执行的输出匹配该正则时结束，记录status为CONDITION_MET的汇总结果
+  until_exit_zero       bool      循环执行的命令执行成功（退出码为0）时结束，与until_match同时设置时需同时满足
+  jitter                string    循环执行每次间隔的随机抖动，秒数、时长字符串或间隔的百分比（如20%%），实际间隔在[delay-jitter, delay+jitter]内且不小于0
+  backoff_factor        float     循环执行连续失败时间隔按该倍数递增，成功一次后恢复为delay，默认不退避
+  backoff_max           duration  连续失败退避后的最长间隔，秒数或时长字符串，默认不限制
+  overlap               string    fixed_rate时到点上一次执行未结束的处理方式：wait等待结束后立即开始（默认）、skip跳过本次、concurrent并发执行，
+                                  skip、concurrent未指定schedule_mode时按fixed_rate执行
+  schedule_mode         string    循环执行的调度方式：fixed_delay每次执行结束后间隔delay（默认）、fixed_rate每隔delay开始一次执行
+  run_at                string    action=at定时执行的时间，RFC3339格式或Unix时间戳（秒），不能早于当前时间
+  collect               bool      多次执行的汇总结果中包含每次执行的结果（results）及成功、失败次数（succeeded、failed），每次的输出受--max-output-bytes限制
+  parallel              int       多次执行同时执行的数量，间隔作用于相邻两次执行的开始之间，汇总结果中按顺序包含每次执行的结果
+  fail_fast             bool      多次执行第一次失败时中止剩余的执行，返回status为ABORTED、已完成次数及失败那次执行的输出
+  exec_id               string    执行ID（请求返回中获得）；执行请求可自行指定（字母、数字、_、-，最长64），该ID正在执行中时返回status为ALREADY_RUNNING而不重复执行
+  exec_ids              []string  action=stop批量停止的执行ID，GET方式可重复传递或以逗号分隔，返回每个ID的处理结果
+  tags                  []string  执行的标签，GET方式可重复传递或以逗号分隔，最多10个，每个最长64，包含在执行结果及日志中
+  tag                   string    action=list、stopAll时仅返回或停止带有该标签的执行
+  callback_url          string    执行结束时POST执行结果（JSON）到该地址，失败时重试，主机须在--callback-allow中允许
+  callback_every_iteration
+                        bool      多次、循环执行每次执行结束都回调
+  workdir               string    命令的工作目录，须位于--allow-workdir允许的目录下
+  env                   map       注入的环境变量，GET方式为 env=KEY=VALUE 可重复，须在--allow-env中允许
+  args                  []string  追加的命令参数，GET方式为 args=xxx 可重复，须开启--allow-args
+  params                map       命令模板参数，GET方式为 params=name=value 可重复
+  stdin                 string    写入命令标准输入的内容，多次/循环执行时每次重复写入
+  stdin_base64          string    base64编码的标准输入，用于二进制内容，与stdin互斥
+  tail                  int       响应中仅返回输出的最后N行，完整输出仍记录在日志中
+  grep                  string    按正则过滤输出，仅返回匹配的行及匹配行数，与tail同时使用时先过滤再取尾部
+  retries               int       单次执行失败后的重试次数，成功或被停止时不再重试
+  retry_delay           duration  重试间隔，秒数或时长字符串
+  dry_run               bool      试运行，返回status为DRY_RUN及将要执行的命令详情，不实际执行
+  timeout               duration  单次命令执行的超时时间，秒数或时长字符串，超时status为TIMEOUT，action=wait时为等待时间
+  async                 bool      异步执行（单次或多次），立即返回202及exec_id，进度及结果通过action=status查询
+  stream                bool      单次执行时以text/plain边执行边返回输出，最后一行为JSON格式的执行结果，客户端断开时停止执行
+  limit                 int       action=result查询循环执行结果、action=history、export查询历史时返回的数量
+  status                string    action=history、export时按执行结果的status过滤
+  since                 string    action=history、export时仅返回该时间（RFC3339格式）之后开始的执行
+  format                string    action=export导出执行历史的格式：csv（默认）、ndjson；单次、多次执行时为text表示只返回命令输出
+  wait                  bool      action=stop时等待命令进程退出后返回最终结果，超过timeout（默认30秒）仍未退出时返回202及status为STOPPING
+  reset                 bool      action=stats时返回统计后清零
+  confirm               bool      action=shutdown时必须为true
+  token_label           string    action=rotate-token轮换的token标签，默认为请求使用的token，仅限POST
+  new_token             string    action=rotate-token的新token，为空时随机生成并在响应中返回（仅返回一次），仅限POST
+  grace                 string    action=rotate-token时旧token继续有效的时长（如10m），默认立即失效，仅限POST
+  ttl                   string    action=mint-token生成的一次性token的有效期，默认1h，最长7天，仅限POST
+  token_actions         []string  action=mint-token生成的一次性token允许的action，为空时不限制，仅限POST
+  filter_action         string    stopAll时仅停止该执行方式（single、multiple、loop）的任务
+  older_than            int       stopAll时仅停止运行超过该秒数的任务
+  command_contains      string    stopAll时仅停止命令包含该内容的任务
 
 GET请求示例：
   单次执行：curl 'http://localhost:8080/path'
   多次执行：curl 'http://localhost:8080/path?action=multiple&count=3'
   循环执行：curl 'http://localhost:8080/path?action=loop&delay=5'
+  定时执行：curl 'http://localhost:8080/path?action=at&run_at=2025-01-01T03:00:00%%2B08:00'，执行前可通过action=stop取消
   停止执行：curl 'http://localhost:8080/path?action=stop&exec_id=xxx'
+  批量停止：curl 'http://localhost:8080/path?action=stop&exec_ids=id1,id2'
+  暂停循环：curl 'http://localhost:8080/path?action=pause&exec_id=xxx'，恢复时action=resume
+  调整间隔：curl 'http://localhost:8080/path?action=update&exec_id=xxx&delay=30'
+  立即执行：curl 'http://localhost:8080/path?action=trigger&exec_id=xxx'
   停止所有：curl 'http://localhost:8080/path?action=stopAll'
+  停止循环：curl 'http://localhost:8080/path?action=stopAll&filter_action=loop&older_than=60'
+  按标签停止：curl 'http://localhost:8080/path?action=stopAll&tag=deploy'，启动时通过tags=deploy指定标签
+  执行列表：curl 'http://localhost:8080/path?action=list'
+  定时列表：curl 'http://localhost:8080/path?action=schedules'，取消定时执行同样使用action=stop
+  服务信息：curl 'http://localhost:8080/path?action=info'，返回版本、平台、时区、运行时长、正在进行的执行数、内存占用等，不包含token及命令
+  执行统计：curl 'http://localhost:8080/path?action=stats'，返回总次数、各status次数、平均及p95耗时、最近失败时间，reset=true时返回后清零
+  校验命令：curl 'http://localhost:8080/path?action=validate&params=branch=main'，检查模板参数、可执行文件、脚本、工作目录并返回渲染后的命令，不实际执行
+  关闭服务：curl -X POST -d '{"action":"shutdown","confirm":true}' http://localhost:8080/path，需启用--allow-shutdown
+  轮换token：curl -X POST -H 'token: xxx' -d '{"action":"rotate-token","grace":"10m"}' http://localhost:8080/path，仅未限制--role的token可调用，新token沿用旧token的过期时间，不需重启服务
+  一次性token：curl -X POST -H 'token: xxx' -d '{"action":"mint-token","ttl":"30m","token_actions":["single"]}' http://localhost:8080/path，
+              返回的token只能使用一次（action不允许、参数无效或试运行时不计为使用），再次使用或过期后返回403，
+              不能用于获取openapi.json；设置--data-dir时重启后仍有效
+  执行状态：curl 'http://localhost:8080/path?action=status&exec_id=xxx'
+  执行结果：curl 'http://localhost:8080/path?action=result&exec_id=xxx'
+  等待结束：curl 'http://localhost:8080/path?action=wait&exec_id=xxx&timeout=60'，timeout内结束时返回执行结果，否则返回202及当前状态，默认等待30秒，最长5分钟
+  实时结果：curl -N 'http://localhost:8080/path?action=stream&exec_id=xxx'，以Server-Sent Events推送每次执行的结果
+  流式输出：curl -N 'http://localhost:8080/path?stream=true'
+  纯文本：curl -H 'Accept: text/plain' 'http://localhost:8080/path'，或format=text，只返回命令输出，COMPLETED时状态码为200，否则为500，exec_id、status、退出码、耗时在X-Remotec-*响应头中
+  实时输出：websocat 'ws://localhost:8080/path?action=attach&exec_id=xxx'，通过WebSocket逐行推送stdout、stderr，结束时推送执行结果
+  执行历史：curl 'http://localhost:8080/path?action=history&status=FAILED&limit=10'
+  导出历史：curl -OJ 'http://localhost:8080/path?action=export&format=csv'
   携带token：curl -H 'token: your_token' 'http://localhost:8080/path'
+  避免重复执行：curl -H 'Idempotency-Key: deploy-20250101' 'http://localhost:8080/path'
+  接口文档：curl 'http://localhost:8080/path/openapi.json'，OpenAPI 3格式，按实际的端点路径及token设置生成
 
 POST请求示例：
   curl -X POST -H "Content-Type: application/json" -H "token: your_token" \
@@ -474,10 +4597,44 @@ POST请求示例：
 
 其他请求示例与GET方式类似，只需将参数放入JSON body即可。
 
+RESTful接口说明（--rest）：
+  POST   /path/v1/executions        创建执行，body同POST请求，action为single（默认）、multiple、loop、at
+  GET    /path/v1/executions        执行列表，同action=list
+  GET    /path/v1/executions/{id}   执行状态，同action=status
+  DELETE /path/v1/executions/{id}   停止执行，同action=stop，可传递查询参数wait=true
+  DELETE /path/v1/executions        停止全部执行，同action=stopAll，可传递tag等过滤参数
+
+请求签名说明（--signing-secret）：
+  请求头X-Remotec-Timestamp为Unix秒数，与服务端时间相差超过5分钟时拒绝；X-Remotec-Nonce为随机字符串（最长128），10分钟内不能重复；
+  X-Remotec-Signature为以下内容的HMAC-SHA256（小写十六进制），各部分以换行分隔，GET请求的查询字符串按原样参与签名：
+    METHOD\nPATH[?QUERY]\nTIMESTAMP\nNONCE\nBODY
+  示例：
+    ts=$(date +%%s); nonce=$(openssl rand -hex 16); body='{"action":"list"}'
+    sig=$(printf 'POST\n/path\n%%s\n%%s\n%%s' "$ts" "$nonce" "$body" | openssl dgst -sha256 -hmac "$SECRET" | sed 's/^.* //')
+    curl -X POST -H "X-Remotec-Timestamp: $ts" -H "X-Remotec-Nonce: $nonce" -H "X-Remotec-Signature: $sig" \
+    -d "$body" http://localhost:8080/path
+
 使用说明：
   1、单次执行和多次执行的结果随Response返回；
-  2、多次执行返回的output为最后一次执行的结果；
+  2、多次执行返回的output为最后一次执行的结果，传递collect=true时results中包含每次执行的结果；
   3、循环执行时Response会立即返回，执行结果通过日志输出；
+  4、传递args时不再经过sh -c或cmd /C包装，命令按空白拆分后直接执行，
+     args作为独立参数原样追加，其中的空格、引号、; | $ 等字符不会被shell解释；
+  5、--no-shell模式下命令按引号规则拆分后直接执行，不支持管道、重定向等shell语法，
+     可执行文件不存在时返回的status为START_FAILED；
+  6、status取值：COMPLETED成功、FAILED命令返回非0退出码（见exit_code）、
+     START_FAILED命令不存在或无执行权限（原因见error）、CANCELED被主动停止、
+     TIMEOUT执行超时；
+  7、停止执行时响应的partial_output为命令在停止前已产生的输出；
+  8、action=status、action=result可查询执行中及最近结束的--max-results个执行，
+     last_output最多返回末尾4096字节；执行中的单次、多次执行查询结果时返回202及status为RUNNING，
+     循环执行返回最近20次执行的结果（新的在前），可通过limit限制数量；
+  9、循环执行的max_duration按实际经过的时间计算，暂停期间同样计时，到期时正在进行的执行被停止且status为EXPIRED，
+     剩余时间可通过action=status的remaining_seconds查看；
+  10、until_match、until_exit_zero条件可能一直不满足，可配合max_iterations、max_duration限制循环执行；
+  11、--redact对响应中的output、stdout、stderr、partial_output及执行历史按整段匹配，可使用(?s)跨行匹配；
+     流式输出（stream=true、action=attach）逐行写出，只能按行匹配，存在可能匹配换行符的规则（如(?s).、\s、[^x]）时
+     这些请求返回400；--output-dir的输出文件此时在执行结束后整段隐藏并一次写入；
 
 `, appConfig.Version)
 }
@@ -0,0 +1,779 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMain(m *testing.M) {
+	setupLogger()
+	if err := initShell(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// 测试期间修改全局配置，测试结束后恢复
+func setGlobal[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+// 只在指定平台运行的用例，goos为空时在所有平台运行
+func skipUnlessGOOS(t *testing.T, goos string) {
+	t.Helper()
+	switch {
+	case goos == "":
+	case goos == "unix" && runtime.GOOS != "windows":
+	case goos == runtime.GOOS:
+	default:
+		t.Skipf("仅在%s下运行", goos)
+	}
+}
+
+// 设置-c指定的命令
+func setCommand(t *testing.T, steps ...string) {
+	t.Helper()
+	setGlobal(t, &commandSteps, stringList(steps))
+	setGlobal(t, &command, strings.Join(steps, " && "))
+}
+
+// 调用接口处理函数，body不为空时以POST发送
+func serveRequest(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/endpoint?"+query, nil)
+	if body != "" {
+		r = httptest.NewRequest(http.MethodPost, "/endpoint?"+query, strings.NewReader(body))
+	}
+	w := httptest.NewRecorder()
+	requestHandler(w, r)
+	return w
+}
+
+func decodeResult(t *testing.T, w *httptest.ResponseRecorder) CommandResult {
+	t.Helper()
+	var result CommandResult
+	decodeJSON(t, w, &result)
+	return result
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("解析响应失败: %v: %s", err, w.Body.String())
+	}
+}
+
+func runTestCommand(t *testing.T, opts ExecOptions) CommandResult {
+	t.Helper()
+	return executeCommand(context.Background(), generateID(), opts)
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		goos     string
+		opts     ExecOptions
+		status   string
+		exitCode int
+	}{
+		{"sh成功", "unix", ExecOptions{Command: "echo ok"}, "COMPLETED", 0},
+		{"sh非零退出", "unix", ExecOptions{Command: "exit 3"}, "FAILED", 3},
+		{"sh命令不存在", "unix", ExecOptions{Command: "remotec_no_such_command"}, "START_FAILED", 127},
+		{"直接执行命令不存在", "", ExecOptions{Command: "remotec_no_such_command", Argv: []string{"remotec_no_such_command"}}, "START_FAILED", -1},
+		{"cmd成功", "windows", ExecOptions{Command: "echo ok"}, "COMPLETED", 0},
+		{"cmd非零退出", "windows", ExecOptions{Command: "exit /b 3"}, "FAILED", 3},
+		{"cmd命令不存在", "windows", ExecOptions{Command: "remotec_no_such_command"}, "START_FAILED", 9009},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipUnlessGOOS(t, tt.goos)
+			result := runTestCommand(t, tt.opts)
+			if result.Status != tt.status || result.ExitCode != tt.exitCode {
+				t.Errorf("status=%s exit_code=%d，期望status=%s exit_code=%d", result.Status, result.ExitCode, tt.status, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestExitCodeInResponse(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "exit 5")
+	for _, action := range []string{"single", "multiple"} {
+		t.Run(action, func(t *testing.T) {
+			w := serveRequest(t, "", `{"action":"`+action+`","times":1}`)
+			var resp map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("解析响应失败: %v", err)
+			}
+			if code, ok := resp["exit_code"].(float64); !ok || code != 5 {
+				t.Errorf("exit_code=%v，期望5: %s", resp["exit_code"], w.Body.String())
+			}
+		})
+	}
+}
+
+// 查找执行中或已结束的执行
+func lookupExecution(id string) *Execution {
+	execLock.Lock()
+	defer execLock.Unlock()
+	if execution, ok := executions[id]; ok {
+		return execution
+	}
+	return finishedExecutions[id]
+}
+
+// 等待执行的命令进程启动
+func waitCommandStarted(t *testing.T, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		execLock.Lock()
+		execution, ok := executions[id]
+		started := ok && execution.Pid != 0
+		execLock.Unlock()
+		if started {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("命令未启动 [ExecID:%s]", id)
+}
+
+func TestStopLoopReportsCanceled(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "sleep 30")
+	started := decodeResult(t, serveRequest(t, "", `{"action":"loop","delay":1}`))
+	waitCommandStarted(t, started.ExecID)
+
+	w := serveRequest(t, "", `{"action":"stop","exec_id":"`+started.ExecID+`","wait":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("停止执行失败: %d %s", w.Code, w.Body.String())
+	}
+	last := lookupExecution(started.ExecID).Last
+	if last == nil || last.Status != "CANCELED" {
+		t.Fatalf("最后一次执行结果为%+v，期望CANCELED", last)
+	}
+	if want := "已通过exec_id " + started.ExecID + "停止执行"; last.Message != want {
+		t.Errorf("message=%q，期望%q", last.Message, want)
+	}
+}
+
+func TestArgsPassedLiterally(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, `printf "[%s]\n"`)
+	setGlobal(t, &allowArgs, true)
+	args := []string{"; rm -rf /", "a b", `"quoted" 'single'`, "$(id) `id` $HOME", "中文 参数", "*"}
+	body, _ := json.Marshal(map[string]interface{}{"args": args})
+	result := decodeResult(t, serveRequest(t, "", string(body)))
+	if result.Status != "COMPLETED" {
+		t.Fatalf("status=%s: %s", result.Status, result.Output)
+	}
+	var want strings.Builder
+	for _, arg := range args {
+		want.WriteString("[" + arg + "]\n")
+	}
+	if result.Output != want.String() {
+		t.Errorf("output=%q，期望%q", result.Output, want.String())
+	}
+}
+
+func TestArgsRequireAllowArgs(t *testing.T) {
+	setCommand(t, "echo")
+	setGlobal(t, &allowArgs, false)
+	w := serveRequest(t, "", `{"args":["x"]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("状态码%d，期望400: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStdin(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "cat")
+	setGlobal(t, &maxOutput, 0)
+	large := strings.Repeat("0123456789abcdef", 4<<16) // 4 MiB
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{"无stdin", map[string]interface{}{}, ""},
+		{"保留末尾换行", map[string]interface{}{"stdin": "line1\nline2\n"}, "line1\nline2\n"},
+		{"无末尾换行", map[string]interface{}{"stdin": "no newline"}, "no newline"},
+		{"多字节字符", map[string]interface{}{"stdin": "中文\r\n\ttab"}, "中文\r\n\ttab"},
+		{"stdin_base64", map[string]interface{}{"stdin_base64": base64.StdEncoding.EncodeToString([]byte("binary\x01\x02\n"))}, "binary\x01\x02\n"},
+		{"数MB的输入", map[string]interface{}{"stdin": large}, large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.params)
+			result := decodeResult(t, serveRequest(t, "", string(body)))
+			if result.Status != "COMPLETED" || result.Output != tt.want {
+				t.Errorf("status=%s，输出%d字节，期望%d字节", result.Status, len(result.Output), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestStdinReplayedForMultiple(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "cat")
+	result := decodeResult(t, serveRequest(t, "", `{"action":"multiple","count":3,"collect":true,"stdin":"same input\n"}`))
+	if len(result.Results) != 3 {
+		t.Fatalf("执行了%d次，期望3次", len(result.Results))
+	}
+	for i, r := range result.Results {
+		if r.Output != "same input\n" {
+			t.Errorf("第%d次执行的输出为%q", i+1, r.Output)
+		}
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  string
+	}{
+		{`uptime`, []string{"uptime"}, ""},
+		{`echo "hello world" 'single quoted'`, []string{"echo", "hello world", "single quoted"}, ""},
+		{`echo a\ b "say \"hi\"" '$HOME'`, []string{"echo", "a b", `say "hi"`, "$HOME"}, ""},
+		{`echo  "" x`, []string{"echo", "", "x"}, ""},
+		{`printf "%s\n" 中文`, []string{"printf", `%s\n`, "中文"}, ""},
+		{`ps aux | grep x`, nil, "不支持的shell语法 '|'"},
+		{`echo x > file`, nil, "不支持的shell语法 '>'"},
+		{`echo $HOME`, nil, "不支持的shell语法 '$'"},
+		{`true && false`, nil, "不支持的shell语法 '&'"},
+		{`echo "unclosed`, nil, "未闭合的引号"},
+		{`   `, nil, "命令为空"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := splitCommandLine(tt.line)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("错误为%v，期望包含%q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, %v，期望%q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoShell(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setGlobal(t, &noShell, true)
+	tests := []struct {
+		name    string
+		command string
+		status  string
+		output  string
+	}{
+		{"带空格的参数", `printf "[%s]" "a b" 'c  d'`, "COMPLETED", "[a b][c  d]"},
+		{"可执行文件不存在", `remotec_no_such_command x`, "START_FAILED", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCommand(t, tt.command)
+			result := decodeResult(t, serveRequest(t, "", "{}"))
+			if result.Status != tt.status || result.Output != tt.output {
+				t.Errorf("status=%s output=%q，期望%s %q", result.Status, result.Output, tt.status, tt.output)
+			}
+			if tt.status == "START_FAILED" && !strings.Contains(result.Error, "executable file not found") {
+				t.Errorf("error=%q，期望说明可执行文件不存在", result.Error)
+			}
+		})
+	}
+}
+
+func TestNoShellRejectsShellSyntax(t *testing.T) {
+	setGlobal(t, &noShell, true)
+	setCommand(t, "ps aux | grep remotec")
+	if err := initNoShell(); err == nil || !strings.Contains(err.Error(), "请使用shell执行") {
+		t.Errorf("错误为%v，期望提示使用shell执行", err)
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("中文输出：成功"))
+	tests := []struct {
+		name    string
+		charset string
+		input   []byte
+		want    string
+	}{
+		{"GBK", "gbk", gbk, "中文输出：成功"},
+		{"GB18030", "gb18030", gbk, "中文输出：成功"},
+		{"UTF-8", "utf-8", []byte("中文输出：成功"), "中文输出：成功"},
+		{"未指定编码", "", []byte("中文输出：成功"), "中文输出：成功"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &outputCharset, tt.charset)
+			setGlobal(t, &outputEncoding, nil)
+			setGlobal(t, &outputEncodingForced, false)
+			if err := initOutputEncoding(); err != nil {
+				t.Fatal(err)
+			}
+			got := decodeOutput(tt.input)
+			if !utf8.ValidString(got) || got != tt.want {
+				t.Errorf("got %q，期望%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputEncodingCommand(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setGlobal(t, &outputCharset, "gbk")
+	setGlobal(t, &outputEncoding, nil)
+	setGlobal(t, &outputEncodingForced, false)
+	if err := initOutputEncoding(); err != nil {
+		t.Fatal(err)
+	}
+	// “中文”的GBK编码
+	result := runTestCommand(t, ExecOptions{Command: `printf '\326\320\316\304'`})
+	if result.Output != "中文" || result.Stdout != "中文" {
+		t.Errorf("output=%q stdout=%q，期望\"中文\"", result.Output, result.Stdout)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		keepHead bool
+		writes   []string
+		want     string
+	}{
+		{"未超出上限", 10, false, []string{"abc", "def"}, "abcdef"},
+		{"不限制", 0, false, []string{"abc", "def"}, "abcdef"},
+		{"保留尾部", 5, false, []string{"abc", "def", "gh"}, "defgh"},
+		{"单次写入超出上限", 5, false, []string{"ab", "0123456789"}, "56789"},
+		{"环形写入跨越末尾", 5, false, []string{"abcde", "fg", "hij"}, "fghij"},
+		{"保留头部", 5, true, []string{"abc", "def", "gh"}, "abcde"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &limitedBuffer{limit: tt.limit, keepHead: tt.keepHead}
+			total := 0
+			for _, s := range tt.writes {
+				b.Write([]byte(s))
+				total += len(s)
+			}
+			if got := string(b.Bytes()); got != tt.want {
+				t.Errorf("got %q，期望%q", got, tt.want)
+			}
+			if b.Total() != int64(total) || b.Truncated() != (tt.limit > 0 && total > tt.limit) {
+				t.Errorf("total=%d truncated=%v", b.Total(), b.Truncated())
+			}
+		})
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setGlobal(t, &maxOutput, 1<<10)
+	result := runTestCommand(t, ExecOptions{Command: "seq 1 10000"})
+	if !result.OutputTruncated || result.OutputBytesTotal != 48894 {
+		t.Fatalf("output_truncated=%v output_bytes_total=%d", result.OutputTruncated, result.OutputBytesTotal)
+	}
+	if len(result.Output) != 1<<10 || !strings.HasSuffix(result.Output, "\n9999\n10000\n") {
+		t.Errorf("应保留输出的最后1KiB，实际%d字节: ...%q", len(result.Output), result.Output[max(len(result.Output)-20, 0):])
+	}
+}
+
+// 输出500MB时内存占用应接近--max-output-bytes而不是输出的大小
+func TestMaxOutputBytesMemory(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	if testing.Short() {
+		t.Skip("输出500MB耗时较长")
+	}
+	setGlobal(t, &maxOutput, 1<<20)
+	runtime.GC()
+	var base runtime.MemStats
+	runtime.ReadMemStats(&base)
+
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var m runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				// 只统计仍被引用的内存，不计尚未回收的临时对象
+				runtime.GC()
+				runtime.ReadMemStats(&m)
+				if m.HeapAlloc > peak {
+					peak = m.HeapAlloc
+				}
+			}
+		}
+	}()
+	result := runTestCommand(t, ExecOptions{Command: "head -c 524288000 /dev/zero"})
+	close(done)
+	<-sampled
+
+	if result.OutputBytesTotal != 500<<20 || len(result.Output) != 1<<20 {
+		t.Fatalf("output_bytes_total=%d，保留%d字节", result.OutputBytesTotal, len(result.Output))
+	}
+	// 采样期间命令仍在持续输出，允许有一定的余量，但应远小于输出的大小
+	if grown := int64(peak) - int64(base.HeapAlloc); grown > 128<<20 {
+		t.Errorf("执行期间堆内存增长%dMB", grown>>20)
+	}
+}
+
+func TestStartFailed(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	noExec := filepath.Join(t.TempDir(), "no-exec.sh")
+	if err := os.WriteFile(noExec, []byte("#!/bin/sh\necho hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		opts     ExecOptions
+		status   string
+		exitCode int
+		err      string
+	}{
+		{"sh命令不存在", ExecOptions{Command: "remotec_no_such_command"}, "START_FAILED", 127, "not found"},
+		{"sh无执行权限", ExecOptions{Command: noExec}, "START_FAILED", 126, "Permission denied"},
+		{"sh执行false", ExecOptions{Command: "false"}, "FAILED", 1, ""},
+		{"直接执行命令不存在", ExecOptions{Argv: []string{"remotec_no_such_command"}}, "START_FAILED", -1, "executable file not found"},
+		{"直接执行无执行权限", ExecOptions{Argv: []string{noExec}}, "START_FAILED", -1, "permission denied"},
+		{"直接执行false", ExecOptions{Argv: []string{"false"}}, "FAILED", 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := runTestCommand(t, tt.opts)
+			if result.Status != tt.status || result.ExitCode != tt.exitCode {
+				t.Errorf("status=%s exit_code=%d，期望%s %d", result.Status, result.ExitCode, tt.status, tt.exitCode)
+			}
+			if (tt.err == "" && result.Error != "") || !strings.Contains(result.Error, tt.err) {
+				t.Errorf("error=%q，期望包含%q", result.Error, tt.err)
+			}
+		})
+	}
+}
+
+func TestCleanEnv(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "env")
+	t.Setenv("REMOTEC_TEST_SECRET", "secret-value")
+	t.Setenv("REMOTEC_TEST_KEEP", "keep-value")
+	tests := []struct {
+		name     string
+		cleanEnv bool
+		keepEnv  stringList
+		present  []string
+		absent   []string
+	}{
+		{"继承环境变量", false, nil, []string{"REMOTEC_TEST_SECRET=secret-value", "REMOTEC_TEST_KEEP=keep-value", "PATH="}, nil},
+		{"--clean-env", true, nil, []string{"PATH="}, []string{"REMOTEC_TEST_SECRET", "REMOTEC_TEST_KEEP"}},
+		{"--keep-env", true, stringList{"REMOTEC_TEST_KEEP"}, []string{"PATH=", "REMOTEC_TEST_KEEP=keep-value"}, []string{"REMOTEC_TEST_SECRET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &cleanEnv, tt.cleanEnv)
+			setGlobal(t, &keepEnv, tt.keepEnv)
+			result := decodeResult(t, serveRequest(t, "", "{}"))
+			lines := strings.Split(result.Output, "\n")
+			for _, want := range tt.present {
+				if !slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, want) }) {
+					t.Errorf("缺少环境变量%s", want)
+				}
+			}
+			for _, name := range tt.absent {
+				if strings.Contains(result.Output, name) {
+					t.Errorf("环境变量%s不应被继承", name)
+				}
+			}
+		})
+	}
+}
+
+func TestTimingFields(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "sleep 0.2")
+	for name, result := range map[string]CommandResult{
+		"执行结果": runTestCommand(t, ExecOptions{Command: "sleep 0.2"}),
+		"接口响应": decodeResult(t, serveRequest(t, "", "{}")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if result.StartTimeUnix == 0 || result.StartTimeUnix > result.EndTimeUnix {
+				t.Errorf("start_time_unix=%d end_time_unix=%d", result.StartTimeUnix, result.EndTimeUnix)
+			}
+			if result.ExecMs < 200 || math.Abs(float64(result.ExecMs)-result.ExecSecond*1000) > 1 {
+				t.Errorf("exec_ms=%d exec_second=%f", result.ExecMs, result.ExecSecond)
+			}
+			if diff := result.EndTimeUnix - result.StartTimeUnix - result.ExecMs; diff < -1 || diff > 1 {
+				t.Errorf("结束时间与开始时间之差与exec_ms相差%dms", diff)
+			}
+			start, err := time.Parse(time.RFC3339, result.ExecTimeISO)
+			if err != nil || start.Unix() != result.StartTimeUnix/1000 {
+				t.Errorf("exec_time_iso=%q与start_time_unix=%d不一致", result.ExecTimeISO, result.StartTimeUnix)
+			}
+			if result.ExecTime != start.In(time.Local).Format(timeFormat) {
+				t.Errorf("exec_time=%q与exec_time_iso=%q不一致", result.ExecTime, result.ExecTimeISO)
+			}
+		})
+	}
+}
+
+// 清空执行历史，测试结束后恢复
+func resetHistory(t *testing.T) {
+	t.Helper()
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	old, oldSeq := history, historySeq
+	history, historySeq = nil, 0
+	t.Cleanup(func() {
+		historyLock.Lock()
+		defer historyLock.Unlock()
+		history, historySeq = old, oldSeq
+	})
+}
+
+func TestHistory(t *testing.T) {
+	resetHistory(t)
+	setGlobal(t, &historySize, 5)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 8; i++ {
+		status := "COMPLETED"
+		if i%2 == 1 {
+			status = "FAILED"
+		}
+		addHistory("loop", CommandResult{ExecID: strconv.Itoa(i), Status: status, StartTimeUnix: base.Add(time.Duration(i) * time.Minute).UnixMilli()})
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"新的在前且不超过history-size", "", []string{"7", "6", "5", "4", "3"}},
+		{"按status过滤", "status=FAILED", []string{"7", "5", "3"}},
+		{"按since过滤", "since=" + url.QueryEscape(base.Add(5*time.Minute).Format(time.RFC3339)), []string{"7", "6", "5"}},
+		{"limit", "limit=2", []string{"7", "6"}},
+		{"组合过滤", "status=COMPLETED&limit=1", []string{"6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(t, "action=history&"+tt.query, "")
+			var entries []HistoryEntry
+			if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("解析响应失败: %v: %s", err, w.Body.String())
+			}
+			var got []string
+			for _, entry := range entries {
+				if entry.Action != "loop" {
+					t.Errorf("action=%s，期望loop", entry.Action)
+				}
+				got = append(got, entry.ExecID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v，期望%v", got, tt.want)
+			}
+		})
+	}
+	if w := serveRequest(t, "action=history&since=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("无效的since应返回400，实际%d", w.Code)
+	}
+}
+
+// 多个执行同时写入执行历史及执行列表，需通过go test -race运行
+func TestHistoryConcurrent(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	resetHistory(t)
+	setGlobal(t, &historySize, 1000)
+	setCommand(t, "echo ok")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w := serveRequest(t, "", `{"action":"multiple","count":10,"parallel":4,"collect":true}`)
+			if w.Code != http.StatusOK {
+				t.Errorf("多次执行失败: %d %s", w.Code, w.Body.String())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				serveRequest(t, "action=history&limit=5", "")
+				serveRequest(t, "action=list", "")
+			}
+		}()
+	}
+	wg.Wait()
+
+	var entries []HistoryEntry
+	if err := json.Unmarshal(serveRequest(t, "action=history", "").Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 40 {
+		t.Errorf("执行历史有%d条，期望40条", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Action != "multiple" || entry.Status != "COMPLETED" {
+			t.Errorf("action=%s status=%s", entry.Action, entry.Status)
+		}
+	}
+}
+
+// 等待执行结束
+func waitExecutionDone(t *testing.T, id string) *Execution {
+	t.Helper()
+	execution := lookupExecution(id)
+	if execution == nil {
+		t.Fatalf("执行不存在 [ExecID:%s]", id)
+	}
+	select {
+	case <-execution.Done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("执行未结束 [ExecID:%s]", id)
+	}
+	return execution
+}
+
+func TestScheduleMode(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "sleep 0.1")
+	tests := []struct {
+		mode    string
+		spacing time.Duration
+	}{
+		// fixed_delay在命令结束后等待间隔，fixed_rate按开始时间计算间隔
+		{"fixed_delay", 300 * time.Millisecond},
+		{"fixed_rate", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			started := decodeResult(t, serveRequest(t, "", `{"action":"loop","delay":"200ms","max_iterations":5,"schedule_mode":"`+tt.mode+`"}`))
+			execution := waitExecutionDone(t, started.ExecID)
+			execLock.Lock()
+			recent := append([]CommandResult(nil), execution.Recent...)
+			execLock.Unlock()
+			if len(recent) != 5 {
+				t.Fatalf("执行了%d次，期望5次", len(recent))
+			}
+			const tolerance = 60 * time.Millisecond
+			for i := 1; i < len(recent); i++ {
+				gap := time.Duration(recent[i].StartTimeUnix-recent[i-1].StartTimeUnix) * time.Millisecond
+				if gap < tt.spacing-tolerance || gap > tt.spacing+tolerance {
+					t.Errorf("第%d次与第%d次的开始时间间隔%s，期望约%s", i, i+1, gap, tt.spacing)
+				}
+			}
+			// 多次执行后累计的偏差不超过单次的误差
+			total := time.Duration(recent[4].StartTimeUnix-recent[0].StartTimeUnix) * time.Millisecond
+			if want := 4 * tt.spacing; tt.mode == "fixed_rate" && (total < want-tolerance || total > want+tolerance) {
+				t.Errorf("第1次到第5次的开始时间间隔%s，期望约%s", total, want)
+			}
+		})
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setGlobal(t, &maxBodyBytes, 256)
+	setGlobal(t, &nonces, make(map[string]time.Time))
+	tests := []struct {
+		name   string
+		secret string // --signing-secret
+		stdin  int    // 请求中stdin的长度
+		code   int
+		runs   int
+	}{
+		{"未超出", "", 10, http.StatusOK, 1},
+		{"超出", "", 1000, http.StatusRequestEntityTooLarge, 0},
+		{"签名请求未超出", "signing-secret", 10, http.StatusOK, 1},
+		{"签名请求超出", "signing-secret", 1000, http.StatusRequestEntityTooLarge, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &signingSecret, tt.secret)
+			runs := countingCommand(t, "")
+			handler := limitBodyMiddleware(authMiddleware(requestHandler))
+
+			body := `{"stdin":"` + strings.Repeat("x", tt.stdin) + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/endpoint", strings.NewReader(body))
+			if tt.secret != "" {
+				signRequest(r, body)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Errorf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+			if n := runs(); n != tt.runs {
+				t.Errorf("命令执行了%d次，期望%d次", n, tt.runs)
+			}
+		})
+	}
+}
+
+// 暂停期间max_duration同样计时，到期时在暂停状态下结束且status为EXPIRED
+func TestPauseCountsTowardMaxDuration(t *testing.T) {
+	skipUnlessGOOS(t, "unix")
+	setCommand(t, "true")
+	started := decodeResult(t, serveRequest(t, "", `{"action":"loop","delay":"100ms","max_duration":"3s"}`))
+	begin := time.Now()
+	if w := serveRequest(t, "", `{"action":"pause","exec_id":"`+started.ExecID+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("暂停失败: %d %s", w.Code, w.Body.String())
+	}
+
+	status := func() ExecutionStatus {
+		t.Helper()
+		var s ExecutionStatus
+		decodeJSON(t, serveRequest(t, "action=status&exec_id="+started.ExecID, ""), &s)
+		return s
+	}
+	first := status()
+	if first.State != "PAUSED" || first.RemainingSeconds == nil {
+		t.Fatalf("暂停后的状态: %+v", first)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	second := status()
+	if second.State != "PAUSED" || second.RemainingSeconds == nil || *second.RemainingSeconds >= *first.RemainingSeconds {
+		t.Errorf("暂停期间remaining_seconds应减少: %v -> %v", *first.RemainingSeconds, second.RemainingSeconds)
+	}
+	if second.Iterations != first.Iterations {
+		t.Errorf("暂停期间仍在执行: %d -> %d", first.Iterations, second.Iterations)
+	}
+
+	execution := waitExecutionDone(t, started.ExecID)
+	if elapsed := time.Since(begin); elapsed > 4*time.Second {
+		t.Errorf("暂停的时间未计入max_duration，%s后才结束", elapsed)
+	}
+	execLock.Lock()
+	result := execution.Result
+	execLock.Unlock()
+	if result == nil || result.Status != "EXPIRED" {
+		t.Fatalf("结束时的结果: %+v", result)
+	}
+}
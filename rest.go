@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// --rest时在端点路径下注册的RESTful路由，与action参数共用同一套处理逻辑
+func registerRestRoutes(endpointPath string) {
+	base := "/" + endpointPath + "/v1/executions"
+	routes := map[string]http.HandlerFunc{
+		"POST " + base:          restCreate,
+		"GET " + base:           restAction("list"),
+		"DELETE " + base:        restAction("stopAll"),
+		"GET " + base + "/{id}": restAction("status"),
+		// 停止执行，可通过查询参数wait=true等待命令进程退出
+		"DELETE " + base + "/{id}": restAction("stop"),
+	}
+	for pattern, handler := range routes {
+		http.HandleFunc(pattern, authMiddleware(handler))
+	}
+}
+
+// POST创建执行，body与action参数方式相同，action为single（默认）、multiple、loop、at
+func restCreate(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseRequestParams(w, r)
+	if !ok {
+		return
+	}
+	if !slices.Contains([]string{"single", "multiple", "loop", "at"}, params.Action) {
+		sendError(w, "action只能为single、multiple、loop、at", http.StatusBadRequest)
+		return
+	}
+	dispatchRequest(w, r, params)
+}
+
+// 查询及停止执行，其余参数从查询参数解析
+func restAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, ok := parseRequestParams(w, r)
+		if !ok {
+			return
+		}
+		params.Action = action
+		if id := r.PathValue("id"); id != "" {
+			params.ExecID = id
+		}
+		dispatchRequest(w, r, params)
+	}
+}
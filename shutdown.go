@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// 监听端口的http.Server，action=shutdown时优雅关闭
+var server *http.Server
+
+// 关闭完成后关闭，startServer等待其关闭后返回
+var shutdownDone = make(chan struct{})
+
+// 关闭服务时等待执行结束及请求处理完成的最长时间
+const shutdownTimeout = 30 * time.Second
+
+// 远程关闭服务：停止全部执行，返回响应后优雅关闭监听，进程以0退出
+func handleShutdown(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if !allowShutdown {
+		sendError(w, "服务端未允许远程关闭", http.StatusForbidden)
+		return
+	}
+	if !params.Confirm {
+		sendError(w, "关闭服务需传递confirm=true", http.StatusBadRequest)
+		return
+	}
+
+	result := StopAllResult{Status: "SHUTTING_DOWN", Stopped: []string{}, Skipped: []string{}}
+	var dones []chan struct{}
+	execLock.Lock()
+	for id, execution := range executions {
+		stopExecutionLocked(execution)
+		result.Stopped = append(result.Stopped, id)
+		dones = append(dones, execution.Done)
+	}
+	execLock.Unlock()
+	sort.Strings(result.Stopped)
+	result.Message = "服务正在关闭"
+	logWarn("收到关闭服务请求，已停止%d个正在执行的任务", len(result.Stopped))
+
+	sendResponse(w, result, http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	go shutdownServer(dones)
+}
+
+func shutdownServer(dones []chan struct{}) {
+	defer close(shutdownDone)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// 等待命令进程退出，执行结果写入执行历史
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	// 不再接受新的连接，等待正在处理的请求（包括本次关闭请求）返回响应
+	if err := server.Shutdown(ctx); err != nil {
+		logWarn("关闭服务超时: %v", err)
+	}
+}
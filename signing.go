@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --signing-secret，设置后请求须携带HMAC-SHA256签名
+var signingSecret string
+
+const (
+	signatureMaxSkew  = 5 * time.Minute
+	signatureNonceTTL = 2 * signatureMaxSkew // 超出时间偏差的请求已被拒绝，nonce无需保留更久
+	maxNonceLength    = 128
+)
+
+// 已使用的nonce及其过期时间
+var (
+	nonceLock  sync.Mutex
+	nonces     = make(map[string]time.Time)
+	noncePrune time.Time
+)
+
+// 签名内容：METHOD\nPATH[?QUERY]\nTIMESTAMP\nNONCE\nBODY，QUERY为原始的查询字符串
+func signaturePayload(r *http.Request, timestamp, nonce string, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(r.Method + "\n")
+	buf.WriteString(r.URL.RequestURI() + "\n")
+	buf.WriteString(timestamp + "\n")
+	buf.WriteString(nonce + "\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 记录nonce，已使用过时返回false
+func useNonce(nonce string, now time.Time) bool {
+	nonceLock.Lock()
+	defer nonceLock.Unlock()
+	if now.Sub(noncePrune) > time.Minute {
+		for n, expires := range nonces {
+			if now.After(expires) {
+				delete(nonces, n)
+			}
+		}
+		noncePrune = now
+	}
+	if expires, ok := nonces[nonce]; ok && now.Before(expires) {
+		return false
+	}
+	nonces[nonce] = now.Add(signatureNonceTTL)
+	return true
+}
+
+// 校验请求签名，时间戳偏差超过5分钟、nonce重复或签名不符时返回403
+func signatureMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timestamp := r.Header.Get("X-Remotec-Timestamp")
+		nonce := r.Header.Get("X-Remotec-Nonce")
+		signature := r.Header.Get("X-Remotec-Signature")
+		if timestamp == "" || nonce == "" || signature == "" {
+			logWarn("签名校验失败，缺少X-Remotec-Timestamp、X-Remotec-Nonce或X-Remotec-Signature")
+			sendError(w, "缺少请求签名", http.StatusForbidden)
+			return
+		}
+		if len(nonce) > maxNonceLength {
+			sendError(w, "X-Remotec-Nonce过长", http.StatusForbidden)
+			return
+		}
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			sendError(w, "无效的X-Remotec-Timestamp，须为Unix秒数", http.StatusForbidden)
+			return
+		}
+		now := time.Now()
+		if skew := now.Sub(time.Unix(sec, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+			logWarn("签名校验失败，时间戳偏差%s", skew.Round(time.Second))
+			sendError(w, "请求时间戳已过期", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if isBodyTooLarge(err) {
+			sendError(w, fmt.Sprintf("请求内容超过%d字节", maxBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			sendError(w, "读取请求内容失败", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		expected := sign(signaturePayload(r, timestamp, nonce, body))
+		if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+			logWarn("签名校验失败，签名不匹配")
+			sendError(w, "请求签名不正确", http.StatusForbidden)
+			return
+		}
+		// 签名正确后再记录nonce，避免伪造的请求占用nonce
+		if !useNonce(nonce, now) {
+			logWarn("签名校验失败，nonce重复: %s", nonce)
+			sendError(w, "nonce已使用", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 按启动参数为处理函数添加token认证、签名校验及审计日志，签名校验先于token认证
+func authMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	if authEnabled() {
+		handler = tokenAuthMiddleware(handler)
+	}
+	if signingSecret != "" {
+		handler = signatureMiddleware(handler)
+	}
+	if auditQueue != nil {
+		handler = auditMiddleware(handler)
+	}
+	return handler
+}
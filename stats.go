@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 执行耗时直方图的桶上限（毫秒），超出最后一个桶的计入溢出桶
+var durationBuckets = []int64{10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000, 600000, 1800000, 3600000}
+
+// 自服务启动（或上次重置）以来的执行统计，每次执行命令后更新
+type ExecStats struct {
+	mu          sync.Mutex
+	since       time.Time
+	total       int64
+	byStatus    map[string]int64
+	durationSum int64   // 毫秒
+	buckets     []int64 // 与durationBuckets对应，最后一个为溢出桶
+	maxDuration int64
+	lastFailure time.Time
+}
+
+var execStats = newExecStats()
+
+func newExecStats() *ExecStats {
+	return &ExecStats{
+		since:    time.Now(),
+		byStatus: make(map[string]int64),
+		buckets:  make([]int64, len(durationBuckets)+1),
+	}
+}
+
+func (s *ExecStats) record(result CommandResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.byStatus[result.Status]++
+	s.durationSum += result.ExecMs
+	s.maxDuration = max64(s.maxDuration, result.ExecMs)
+	i := 0
+	for i < len(durationBuckets) && result.ExecMs > durationBuckets[i] {
+		i++
+	}
+	s.buckets[i]++
+	if result.Status != "COMPLETED" {
+		s.lastFailure = time.Now()
+	}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 按直方图估算分位数，取所在桶的上限，溢出桶取最大耗时
+func (s *ExecStats) quantile(q float64) int64 {
+	if s.total == 0 {
+		return 0
+	}
+	rank := int64(float64(s.total)*q + 0.999999)
+	var seen int64
+	for i, n := range s.buckets {
+		seen += n
+		if seen >= rank {
+			if i < len(durationBuckets) {
+				return min(durationBuckets[i], s.maxDuration)
+			}
+			break
+		}
+	}
+	return s.maxDuration
+}
+
+type StatsResult struct {
+	Since       string           `json:"since"`
+	Total       int64            `json:"total"`
+	Completed   int64            `json:"completed"`
+	Failed      int64            `json:"failed"` // 结果不是COMPLETED的次数
+	Timeout     int64            `json:"timeout"`
+	ByStatus    map[string]int64 `json:"by_status"`
+	AvgMs       int64            `json:"avg_ms"`
+	P95Ms       int64            `json:"p95_ms"`
+	MaxMs       int64            `json:"max_ms"`
+	LastFailure string           `json:"last_failure,omitempty"`
+	Reset       bool             `json:"reset,omitempty"`
+}
+
+func (s *ExecStats) snapshot() StatsResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+// 在同一次加锁内取统计并清零，两者之间结束的执行不会被漏计
+func (s *ExecStats) snapshotAndReset() StatsResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := s.snapshotLocked()
+	fresh := newExecStats()
+	s.since, s.total, s.byStatus = fresh.since, 0, fresh.byStatus
+	s.durationSum, s.buckets, s.maxDuration = 0, fresh.buckets, 0
+	s.lastFailure = time.Time{}
+	result.Reset = true
+	return result
+}
+
+// 调用方须持有s.mu
+func (s *ExecStats) snapshotLocked() StatsResult {
+	result := StatsResult{
+		Since:     s.since.In(time.Local).Format(timeFormat),
+		Total:     s.total,
+		Completed: s.byStatus["COMPLETED"],
+		Failed:    s.total - s.byStatus["COMPLETED"],
+		Timeout:   s.byStatus["TIMEOUT"],
+		ByStatus:  make(map[string]int64, len(s.byStatus)),
+		P95Ms:     s.quantile(0.95),
+		MaxMs:     s.maxDuration,
+	}
+	for status, n := range s.byStatus {
+		result.ByStatus[status] = n
+	}
+	if s.total > 0 {
+		result.AvgMs = s.durationSum / s.total
+	}
+	if !s.lastFailure.IsZero() {
+		result.LastFailure = s.lastFailure.Format(timeFormat)
+	}
+	return result
+}
+
+// 返回执行统计，reset=true时返回重置前的统计并清零，只读模式下不允许重置
+func handleStats(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.Reset && readOnly {
+		sendError(w, "只读模式下不允许重置执行统计", http.StatusForbidden)
+		return
+	}
+	if !params.Reset {
+		sendResponse(w, execStats.snapshot(), http.StatusOK)
+		return
+	}
+	stats := execStats.snapshotAndReset()
+	logInfo("已重置执行统计")
+	sendResponse(w, stats, http.StatusOK)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestExecStatsSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []CommandResult
+		want    StatsResult
+	}{
+		{"无执行", nil, StatsResult{ByStatus: map[string]int64{}}},
+		{
+			"混合结果",
+			[]CommandResult{
+				{Status: "COMPLETED", ExecMs: 5},
+				{Status: "COMPLETED", ExecMs: 40},
+				{Status: "FAILED", ExecMs: 200},
+				{Status: "TIMEOUT", ExecMs: 7000},
+			},
+			StatsResult{
+				Total: 4, Completed: 2, Failed: 2, Timeout: 1,
+				ByStatus: map[string]int64{"COMPLETED": 2, "FAILED": 1, "TIMEOUT": 1},
+				AvgMs:    1811, P95Ms: 7000, MaxMs: 7000,
+			},
+		},
+		{
+			"分位数取桶上限",
+			[]CommandResult{{Status: "COMPLETED", ExecMs: 30}, {Status: "COMPLETED", ExecMs: 30}, {Status: "COMPLETED", ExecMs: 60}},
+			StatsResult{Total: 3, Completed: 3, ByStatus: map[string]int64{"COMPLETED": 3}, AvgMs: 40, P95Ms: 60, MaxMs: 60},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newExecStats()
+			for _, r := range tt.results {
+				s.record(r)
+			}
+			got := s.snapshot()
+			if got.Total != tt.want.Total || got.Completed != tt.want.Completed || got.Failed != tt.want.Failed ||
+				got.Timeout != tt.want.Timeout || got.AvgMs != tt.want.AvgMs || got.P95Ms != tt.want.P95Ms || got.MaxMs != tt.want.MaxMs {
+				t.Errorf("snapshot() = %+v，期望%+v", got, tt.want)
+			}
+			for status, n := range tt.want.ByStatus {
+				if got.ByStatus[status] != n {
+					t.Errorf("ByStatus[%s] = %d，期望%d", status, got.ByStatus[status], n)
+				}
+			}
+			if (got.LastFailure != "") != (tt.want.Failed > 0) {
+				t.Errorf("LastFailure = %q", got.LastFailure)
+			}
+		})
+	}
+}
+
+func TestHandleStatsReset(t *testing.T) {
+	setGlobal(t, &execStats, newExecStats())
+	execStats.record(CommandResult{Status: "COMPLETED", ExecMs: 10})
+
+	tests := []struct {
+		name  string
+		query string
+		total int64
+		reset bool
+	}{
+		{"查询不清零", "action=stats", 1, false},
+		{"返回重置前的统计", "action=stats&reset=true", 1, true},
+		{"重置后为零", "action=stats", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got StatsResult
+			decodeJSON(t, serveRequest(t, tt.query, ""), &got)
+			if got.Total != tt.total || got.Reset != tt.reset {
+				t.Errorf("total=%d reset=%v，期望total=%d reset=%v", got.Total, got.Reset, tt.total, tt.reset)
+			}
+		})
+	}
+}
+
+// 重置与记录并发时，每次执行恰好被计入一次
+func TestSnapshotAndResetConcurrent(t *testing.T) {
+	s := newExecStats()
+	const writers, records = 8, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				s.record(CommandResult{Status: "COMPLETED", ExecMs: 1})
+			}
+		}()
+	}
+	done := make(chan struct{})
+	var counted int64
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			counted += s.snapshotAndReset().Total
+		}
+	}()
+	wg.Wait()
+	<-done
+	counted += s.snapshot().Total
+	if counted != writers*records {
+		t.Errorf("共计入%d次，期望%d次", counted, writers*records)
+	}
+}
+
+func TestStatsResetReadOnly(t *testing.T) {
+	setGlobal(t, &execStats, newExecStats())
+	setGlobal(t, &readOnly, true)
+	execStats.record(CommandResult{Status: "COMPLETED", ExecMs: 10})
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		code  int
+	}{
+		{"查询", "action=stats", "", http.StatusOK},
+		{"重置", "action=stats&reset=true", "", http.StatusForbidden},
+		{"POST重置", "", `{"action":"stats","reset":true}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveRequest(t, tt.query, tt.body); w.Code != tt.code {
+				t.Errorf("状态码%d，期望%d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+	if n := execStats.snapshot().Total; n != 1 {
+		t.Errorf("只读模式下统计被重置，total=%d", n)
+	}
+}
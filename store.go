@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	historyBucket   = []byte("history")
+	executionBucket = []byte("executions")
+)
+
+// --data-dir下的数据文件，未设置或打开失败时为nil，仅在内存中保留记录
+var store *bolt.DB
+
+// 待写入数据文件的操作，value为nil时表示删除
+type storeOp struct {
+	bucket []byte
+	key    []byte
+	value  []byte
+}
+
+// 写入在后台批量进行，队列满时丢弃，不影响命令执行
+var storeQueue = make(chan storeOp, 1024)
+
+const storeBatchSize = 256
+
+// 打开--data-dir下的数据文件，恢复执行历史及被中断的循环执行
+func initDataDir() error {
+	if dataDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	path := filepath.Join(dataDir, "remotec.db")
+	db, err := openStore(path)
+	if errors.Is(err, bolt.ErrTimeout) {
+		logWarn("数据文件被其他进程占用，执行记录将不会持久化: %s", path)
+		return nil
+	}
+	if err != nil {
+		// 数据文件损坏时移到一旁并重新创建，不影响服务启动
+		corrupt := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102150405"))
+		logWarn("打开数据文件失败，已移至%s: %v", corrupt, err)
+		if err := os.Rename(path, corrupt); err != nil {
+			logWarn("移动数据文件失败，执行记录将不会持久化: %v", err)
+			return nil
+		}
+		if db, err = openStore(path); err != nil {
+			logWarn("创建数据文件失败，执行记录将不会持久化: %v", err)
+			return nil
+		}
+	}
+	store = db
+
+	if err := restoreHistory(); err != nil {
+		logWarn("恢复执行历史失败: %v", err)
+	}
+	if err := restoreInterrupted(); err != nil {
+		logWarn("恢复循环执行记录失败: %v", err)
+	}
+	if err := restoreOneTimeTokens(); err != nil {
+		logWarn("恢复一次性token失败: %v", err)
+	}
+	go storeWriter()
+	logInfo("执行记录持久化到：%s", path)
+	return nil
+}
+
+func openStore(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyBucket, executionBucket, oneTimeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// 将最近的--history-size条历史加载到内存
+func restoreHistory() error {
+	var restored []HistoryEntry
+	err := store.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(restored) < historySize; k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			entry.Seq = binary.BigEndian.Uint64(k)
+			restored = append(restored, entry)
+		}
+		if k, _ := c.Last(); k != nil {
+			historySeq = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	for i := len(restored) - 1; i >= 0; i-- {
+		history = append(history, restored[i])
+	}
+	return nil
+}
+
+// 上次运行时未结束的循环执行标记为被中断，可通过action=list、action=status查看
+func restoreInterrupted() error {
+	var interrupted []ExecutionInfo
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(executionBucket)
+		err := b.ForEach(func(k, v []byte) error {
+			var info ExecutionInfo
+			if err := json.Unmarshal(v, &info); err == nil {
+				interrupted = append(interrupted, info)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// 被中断的记录只在本次运行期间保留
+		for _, info := range interrupted {
+			if err := b.Delete([]byte(info.ExecID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	execLock.Lock()
+	defer execLock.Unlock()
+	for _, info := range interrupted {
+		startTime, _ := time.ParseInLocation(timeFormat, info.StartTime, time.Local)
+		logWarn("上次运行时被中断的循环执行 [ExecID:%s]: %s", info.ExecID, info.Command)
+		finishExecutionLocked(&Execution{
+			ID:          info.ExecID,
+			Action:      info.Action,
+			Command:     info.Command,
+			StartTime:   startTime,
+			Iterations:  info.Iterations,
+			Interrupted: true,
+		})
+	}
+	return nil
+}
+
+// 将写入操作加入后台队列
+func enqueueStore(op storeOp) {
+	if store == nil {
+		return
+	}
+	select {
+	case storeQueue <- op:
+	default:
+		logWarn("数据文件写入队列已满，丢弃记录")
+	}
+}
+
+func persistHistory(entry HistoryEntry) {
+	if store == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	enqueueStore(storeOp{bucket: historyBucket, key: seqKey(entry.Seq), value: data})
+}
+
+// 记录正在运行的循环执行，服务异常退出后可识别为被中断
+func persistExecution(info ExecutionInfo) {
+	if store == nil {
+		return
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	enqueueStore(storeOp{bucket: executionBucket, key: []byte(info.ExecID), value: data})
+}
+
+func removeExecution(id string) {
+	enqueueStore(storeOp{bucket: executionBucket, key: []byte(id)})
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// 后台批量写入数据文件，并定期清理超出--data-retention的历史
+func storeWriter() {
+	var lastPrune time.Time
+	for op := range storeQueue {
+		batch := []storeOp{op}
+	drain:
+		for len(batch) < storeBatchSize {
+			select {
+			case op := <-storeQueue:
+				batch = append(batch, op)
+			default:
+				break drain
+			}
+		}
+		prune := time.Since(lastPrune) > time.Hour
+		err := store.Update(func(tx *bolt.Tx) error {
+			for _, op := range batch {
+				b := tx.Bucket(op.bucket)
+				var err error
+				if op.value == nil {
+					err = b.Delete(op.key)
+				} else {
+					err = b.Put(op.key, op.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if prune {
+				return pruneStoredHistory(tx)
+			}
+			return nil
+		})
+		if err != nil {
+			logWarn("写入数据文件失败: %v", err)
+		} else if prune {
+			lastPrune = time.Now()
+		}
+	}
+}
+
+func pruneStoredHistory(tx *bolt.Tx) error {
+	if dataRetention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-dataRetention).UnixMilli()
+	c := tx.Bucket(historyBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(v, &entry); err == nil && entry.StartTimeUnix >= cutoff {
+			break
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从数据文件中按从新到旧读取序号小于before的历史，fn返回false时停止
+func readStoredHistory(before uint64, fn func(HistoryEntry) bool) {
+	if store == nil {
+		return
+	}
+	err := store.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		k, v := c.Seek(seqKey(before))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			entry.Seq = binary.BigEndian.Uint64(k)
+			if !fn(entry) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logWarn("读取数据文件失败: %v", err)
+	}
+}
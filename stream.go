@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+// 订阅执行结果的缓冲区，订阅方来不及接收时丢弃，不影响命令执行
+const subscriberBuffer = 16
+
+// 订阅执行的每次执行结果，调用方需持有execLock
+func subscribeLocked(execution *Execution) chan CommandResult {
+	ch := make(chan CommandResult, subscriberBuffer)
+	if execution.Subscribers == nil {
+		execution.Subscribers = make(map[chan CommandResult]struct{})
+	}
+	execution.Subscribers[ch] = struct{}{}
+	return ch
+}
+
+func unsubscribe(id string, ch chan CommandResult) {
+	updateExecution(id, func(e *Execution) { delete(e.Subscribers, ch) })
+}
+
+// 将一次执行的结果发送给所有订阅方，调用方需持有execLock
+func publishLocked(execution *Execution, result CommandResult) {
+	for ch := range execution.Subscribers {
+		select {
+		case ch <- result:
+		default:
+			logWarn("订阅方接收过慢，丢弃一次执行结果 [ExecID:%s]", execution.ID)
+		}
+	}
+}
+
+// 命令输出的一行
+type OutputLine struct {
+	Stream string // stdout或stderr
+	Line   string
+}
+
+// 输出行的订阅方缓冲区，订阅方来不及接收时断开该订阅方，不影响命令执行
+const lineSubscriberBuffer = 256
+
+// 所有执行的输出行订阅方数量，为0时无需广播
+var lineSubscribers atomic.Int32
+
+// 订阅执行的输出行，调用方需持有execLock
+func subscribeLinesLocked(execution *Execution) chan OutputLine {
+	ch := make(chan OutputLine, lineSubscriberBuffer)
+	if execution.LineSubscribers == nil {
+		execution.LineSubscribers = make(map[chan OutputLine]struct{})
+	}
+	execution.LineSubscribers[ch] = struct{}{}
+	lineSubscribers.Add(1)
+	return ch
+}
+
+func unsubscribeLines(id string, ch chan OutputLine) {
+	updateExecution(id, func(e *Execution) {
+		if _, ok := e.LineSubscribers[ch]; ok {
+			delete(e.LineSubscribers, ch)
+			lineSubscribers.Add(-1)
+		}
+	})
+}
+
+func publishLines(id string, lines []OutputLine) {
+	updateExecution(id, func(e *Execution) {
+		for ch := range e.LineSubscribers {
+			for _, line := range lines {
+				select {
+				case ch <- line:
+					continue
+				default:
+				}
+				// 接收过慢的订阅方直接断开，避免阻塞命令输出
+				logWarn("输出订阅方接收过慢，已断开 [ExecID:%s]", id)
+				delete(e.LineSubscribers, ch)
+				lineSubscribers.Add(-1)
+				close(ch)
+				break
+			}
+		}
+	})
+}
+
+// 将命令输出按行广播给订阅方，不完整的行在flush时发送
+type lineWriter struct {
+	execID  string
+	stream  string
+	mu      sync.Mutex
+	partial []byte
+}
+
+func newLineWriter(execID, stream string) *lineWriter {
+	return &lineWriter{execID: execID, stream: stream}
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.partial = append(lw.partial, p...)
+	var lines []OutputLine
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(lw.partial[:i], []byte("\r"))
+		lines = append(lines, OutputLine{Stream: lw.stream, Line: decodeOutput(line)})
+		lw.partial = lw.partial[i+1:]
+	}
+	// 超长的行不再等待换行，避免无限占用内存
+	if len(lw.partial) > 64<<10 {
+		lines = append(lines, OutputLine{Stream: lw.stream, Line: decodeOutput(lw.partial)})
+		lw.partial = nil
+	}
+	if len(lines) > 0 && lineSubscribers.Load() > 0 {
+		publishLines(lw.execID, lines)
+	}
+	return len(p), nil
+}
+
+func (lw *lineWriter) flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.partial) > 0 && lineSubscribers.Load() > 0 {
+		publishLines(lw.execID, []OutputLine{{Stream: lw.stream, Line: decodeOutput(lw.partial)}})
+	}
+	lw.partial = nil
+}
+
+// action=attach推送的消息，type为line时为一行输出，为result时为执行结束后的结果
+type AttachMessage struct {
+	Type   string      `json:"type"`
+	Stream string      `json:"stream,omitempty"`
+	Line   string      `json:"line,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// 升级为WebSocket连接，实时推送执行的stdout、stderr输出行，执行结束时推送结果并关闭连接
+// 客户端断开只结束推送，不影响执行本身
+func handleAttach(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if err := checkStreamRedact(); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+	if !isWebSocketUpgrade(r) {
+		sendError(w, "需要WebSocket升级请求", http.StatusBadRequest)
+		return
+	}
+
+	execLock.Lock()
+	execution, running := executions[params.ExecID]
+	if !running {
+		var ok bool
+		if execution, ok = finishedExecutions[params.ExecID]; !ok {
+			execLock.Unlock()
+			sendError(w, "无效的exec_id", http.StatusNotFound)
+			return
+		}
+	}
+	var ch chan OutputLine
+	if running {
+		ch = subscribeLinesLocked(execution)
+		defer unsubscribeLines(params.ExecID, ch)
+	}
+	done := execution.Done
+	execLock.Unlock()
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(closed)
+	}()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			ws.conn.Close()
+			return
+		case line, ok := <-ch:
+			if !ok {
+				ws.close(1008, "接收过慢，已断开")
+				return
+			}
+			if ws.writeJSON(AttachMessage{Type: "line", Stream: line.Stream, Line: line.Line}) != nil {
+				ws.conn.Close()
+				return
+			}
+		case <-done:
+			for len(ch) > 0 {
+				line := <-ch
+				ws.writeJSON(AttachMessage{Type: "line", Stream: line.Stream, Line: line.Line})
+			}
+			ws.writeJSON(AttachMessage{Type: "result", Result: withIdentity(finalResult(params.ExecID))})
+			ws.close(1000, "")
+			return
+		case <-ping.C:
+			ws.writeFrame(wsOpPing, nil)
+		}
+	}
+}
+
+// 写入后立即flush的响应，供命令的stdout、stderr并发写入
+type flushWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	last    byte // 最后写入的字节，用于判断结果行前是否需要换行
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// 客户端断开后写入失败时由请求的ctx停止命令，不影响输出的采集
+	fw.w.Write(p)
+	fw.flusher.Flush()
+	fw.last = p[len(p)-1]
+	return len(p), nil
+}
+
+// 流式单次执行：以text/plain边执行边返回输出，最后一行为JSON格式的执行结果
+// 执行与请求绑定，客户端断开时停止执行
+func handleSingleStream(w http.ResponseWriter, r *http.Request, params RequestParams, opts ExecOptions) {
+	if params.Async {
+		sendError(w, "stream不能与async同时使用", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Idempotency-Key") != "" {
+		sendError(w, "stream不支持Idempotency-Key", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	startTime := time.Now()
+	execID := requestExecID(params)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	if !registerExecution(execID, "single", opts.Command, params, cancel) {
+		sendAlreadyRunning(w, execID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Remotec-Exec-Id", execID)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	out := &flushWriter{w: w, flusher: flusher, last: '\n'}
+	opts.Output = out
+	for i := range opts.Steps {
+		opts.Steps[i].Output = out
+	}
+	result := executeWithRetry(ctx, execID, opts, params.Retries, time.Duration(params.RetryDelay))
+	if result.Status == "CANCELED" && r.Context().Err() != nil {
+		result.Message = "客户端断开，已停止执行"
+	}
+	recordIteration(execID, result)
+	cleanExecution(execID)
+
+	// 输出已经返回，结果行中不再重复
+	result.Output, result.Stdout, result.Stderr = "", "", ""
+	for i := range result.Steps {
+		result.Steps[i].Output = ""
+	}
+	result.Message = joinMessage("单次执行", result.Message)
+	result.setTiming(startTime, time.Now())
+	b, err := json.Marshal(withIdentity(result))
+	if err != nil {
+		return
+	}
+	if out.last != '\n' {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// 以Server-Sent Events推送执行的每次执行结果，执行结束时推送end事件
+// 客户端断开只结束推送，不影响执行本身
+func handleStream(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	if params.ExecID == "" {
+		sendError(w, "缺少exec_id参数", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	execLock.Lock()
+	execution, running := executions[params.ExecID]
+	if !running {
+		execution, ok = finishedExecutions[params.ExecID]
+		if !ok {
+			execLock.Unlock()
+			sendError(w, "无效的exec_id", http.StatusNotFound)
+			return
+		}
+	}
+	var ch chan CommandResult
+	if running {
+		ch = subscribeLocked(execution)
+		defer unsubscribe(params.ExecID, ch)
+	}
+	done := execution.Done
+	execLock.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case result := <-ch:
+			writeEvent(w, "result", result)
+		case <-done:
+			// 先推送尚未发送的结果
+			for len(ch) > 0 {
+				writeEvent(w, "result", <-ch)
+			}
+			writeEvent(w, "end", finalResult(params.ExecID))
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	b, err := json.Marshal(withIdentity(data))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+// 执行结束后的结果：汇总结果或最后一次执行的结果
+func finalResult(id string) interface{} {
+	var result interface{} = map[string]string{"exec_id": id}
+	updateExecution(id, func(e *Execution) {
+		switch {
+		case e.Result != nil:
+			result = *e.Result
+		case e.Last != nil:
+			result = *e.Last
+		}
+	})
+	return result
+}
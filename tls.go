@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	tlsCert string // --tls-cert，PEM格式的证书文件
+	tlsKey  string // --tls-key，PEM格式的私钥文件
+)
+
+// 检查证书文件是否更新的最小间隔
+const certCheckInterval = 10 * time.Second
+
+// 证书文件修改后在下次握手时重新加载，续期证书无需重启服务
+type certReloader struct {
+	certFile, keyFile string
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	certMod, keyMod   time.Time
+	lastCheck         time.Time
+}
+
+// 握手时返回的证书，未启用TLS时为nil
+var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func tlsEnabled() bool {
+	return getCertificate != nil
+}
+
+// 校验--tls-cert、--tls-key并加载证书，或生成自签名证书
+func initTLS() error {
+	if (tlsCert == "") != (tlsKey == "") {
+		return errors.New("--tls-cert与--tls-key须同时指定")
+	}
+	if len(acmeDomains) > 0 && (tlsCert != "" || tlsSelfSigned) {
+		return errors.New("--acme-domain不能与--tls-cert、--tls-key、--tls-self-signed同时使用")
+	}
+	if tlsSelfSigned {
+		if tlsCert != "" {
+			return errors.New("--tls-self-signed不能与--tls-cert、--tls-key同时使用")
+		}
+		return initSelfSigned()
+	}
+	if tlsCert == "" {
+		return nil
+	}
+	cr := &certReloader{certFile: tlsCert, keyFile: tlsKey}
+	if err := cr.load(); err != nil {
+		return err
+	}
+	getCertificate = cr.GetCertificate
+	return nil
+}
+
+func (cr *certReloader) load() error {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return fmt.Errorf("读取TLS证书失败: %v", err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("读取TLS私钥失败: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %v", err)
+	}
+	cr.cert = &cert
+	cr.certMod, cr.keyMod = certInfo.ModTime(), keyInfo.ModTime()
+	if cert.Leaf != nil {
+		logInfo("已加载TLS证书：%s，有效期至%s", cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter.In(time.Local).Format(timeFormat))
+	}
+	return nil
+}
+
+// 证书或私钥文件的修改时间变化时重新加载，加载失败时继续使用原证书
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if time.Since(cr.lastCheck) < certCheckInterval {
+		return cr.cert, nil
+	}
+	cr.lastCheck = time.Now()
+	certInfo, err1 := os.Stat(cr.certFile)
+	keyInfo, err2 := os.Stat(cr.keyFile)
+	if err1 != nil || err2 != nil {
+		return cr.cert, nil
+	}
+	if certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return cr.cert, nil
+	}
+	if err := cr.load(); err != nil {
+		logWarn("重新加载TLS证书失败，继续使用原证书: %v", err)
+	}
+	return cr.cert, nil
+}
+
+// 监听地址的协议
+func serverScheme() string {
+	if tlsEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// 按是否启用TLS开始监听，server关闭后返回http.ErrServerClosed
+func listenAndServe() error {
+	if !tlsEnabled() {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+	if acmeManager != nil {
+		// 同时支持TLS-ALPN-01验证
+		server.TLSConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// --tls-self-signed，启动时生成自签名证书
+var tlsSelfSigned bool
+
+// 自签名证书的有效期
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// 生成自签名证书，设置--data-dir时保存到数据目录，重启后继续使用同一证书
+func initSelfSigned() error {
+	var certFile, keyFile string
+	if dataDir != "" {
+		certFile = filepath.Join(dataDir, "tls-self-signed.crt")
+		keyFile = filepath.Join(dataDir, "tls-self-signed.key")
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+			logInfo("使用数据目录中的自签名证书：%s", certFile)
+			return useSelfSigned(cert, certFile, keyFile)
+		}
+	}
+	certPEM, keyPEM, err := generateSelfSigned()
+	if err != nil {
+		return fmt.Errorf("生成自签名证书失败: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("生成自签名证书失败: %v", err)
+	}
+	if certFile != "" {
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			logWarn("保存自签名证书失败，重启后将重新生成: %v", err)
+			certFile = ""
+		} else if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+			logWarn("保存自签名证书失败，重启后将重新生成: %v", err)
+			certFile = ""
+		} else {
+			logInfo("已生成自签名证书并保存到：%s", certFile)
+		}
+	}
+	return useSelfSigned(cert, certFile, keyFile)
+}
+
+func useSelfSigned(cert tls.Certificate, certFile, keyFile string) error {
+	sum := sha256.Sum256(cert.Certificate[0])
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+	logInfo("自签名证书SHA-256指纹：%s", strings.Join(fingerprint, ":"))
+	if certFile == "" {
+		getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil }
+		return nil
+	}
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return err
+	}
+	getCertificate = cr.GetCertificate
+	return nil
+}
+
+// 生成ECDSA P-256私钥及自签名证书，SAN包含主机名、localhost及本机的IP地址
+func generateSelfSigned() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"remotec"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	// 监听全部地址，SAN包含各网卡的IP
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
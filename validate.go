@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// shell的关键字及内置命令，不是可执行文件，校验时跳过
+var shellBuiltins = []string{
+	"if", "for", "while", "until", "case", "do", "done", "then", "fi", "esac", "{", "(", "!",
+	"cd", "echo", "exit", "export", "set", "unset", "source", ".", "test", "[", "true", "false",
+	"exec", "eval", "read", "shift", "trap", "ulimit", "umask", "wait", "type", "alias", ":",
+	"call", "dir", "del", "copy", "move", "ren", "md", "rd", "cls", "start", "ver", "vol",
+}
+
+type ValidateResult struct {
+	Valid   bool         `json:"valid"`
+	Command string       `json:"command,omitempty"` // 渲染模板参数后的命令
+	Argv    []string     `json:"argv,omitempty"`
+	Steps   [][]string   `json:"steps,omitempty"`
+	Workdir string       `json:"workdir,omitempty"`
+	Checks  []ReadyCheck `json:"checks"`
+}
+
+// 校验命令能否执行：模板参数、可执行文件、脚本、工作目录，不启动任何进程，与--check对应
+func handleValidate(w http.ResponseWriter, r *http.Request, params RequestParams) {
+	result := ValidateResult{Valid: true}
+	opts, err := buildExecOptions(params)
+	result.Checks = append(result.Checks, newReadyCheck("params", err))
+	if err == nil {
+		result.Command = opts.Command
+		result.Workdir = opts.Workdir
+		steps := opts.Steps
+		if len(steps) == 0 {
+			steps = []ExecOptions{opts}
+			result.Argv = commandArgv(opts)
+		}
+		for _, step := range steps {
+			if len(opts.Steps) > 0 {
+				result.Steps = append(result.Steps, commandArgv(step))
+			}
+			result.Checks = append(result.Checks, executableChecks(step)...)
+		}
+		if scriptPath != "" {
+			result.Checks = append(result.Checks, newReadyCheck("script", checkReadable(scriptPath)))
+		}
+		result.Checks = append(result.Checks, newReadyCheck("workdir", checkWorkdir(opts.Workdir)))
+	}
+	for _, check := range result.Checks {
+		result.Valid = result.Valid && check.OK
+	}
+	sendResponse(w, result, http.StatusOK)
+}
+
+// 检查实际启动的程序，经shell执行时同时检查命令中的第一个程序
+func executableChecks(opts ExecOptions) []ReadyCheck {
+	argv := commandArgv(opts)
+	checks := []ReadyCheck{newReadyCheck("executable", checkExecutable(argv[0]))}
+	if len(opts.Argv) > 0 || scriptPath != "" {
+		return checks
+	}
+	words, err := splitCommandLine(opts.Command)
+	if err != nil {
+		return append(checks, newReadyCheck("command", err))
+	}
+	for _, word := range words {
+		// 跳过命令前的环境变量赋值
+		if name, _, ok := strings.Cut(word, "="); ok && name != "" && !strings.ContainsAny(name, `/\`) {
+			continue
+		}
+		if !slices.Contains(shellBuiltins, strings.ToLower(word)) {
+			checks = append(checks, newReadyCheck("command", checkExecutable(word)))
+		}
+		break
+	}
+	return checks
+}
+
+func checkWorkdir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}